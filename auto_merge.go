@@ -0,0 +1,176 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"sync"
+	"time"
+)
+
+// 后台自动merge的状态
+type AutoMergeStat struct {
+	Enabled   bool      // 是否开启
+	Running   bool      // 是否正在merge
+	Merges    uint64    // 完成merge的次数
+	LastCheck time.Time // 最近一次检查的时间
+	LastMerge time.Time // 最近一次完成merge的时间
+	LastError error     // 最近一次merge失败的原因
+}
+
+// 后台自动merge，定期检查无效数据的比例，达到 DataFileMergeRatio 并且在允许的时间段内时执行merge
+type autoMerger struct {
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+	state AutoMergeStat
+}
+
+func (am *autoMerger) stat() AutoMergeStat {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.state
+}
+
+// 启动后台自动merge
+func (db *DB) startAutoMerge() {
+	db.autoMerge.state.Enabled = true
+	db.autoMerge.wg.Add(1)
+	go db.runAutoMerge()
+}
+
+// 停止后台任务，等待正在进行的merge退出
+func (db *DB) stopBackgroundTasks() {
+	select {
+	case <-db.closeCh:
+	default:
+		close(db.closeCh)
+	}
+	db.autoMerge.wg.Wait()
+	db.indexSnapshotWg.Wait()
+}
+
+func (db *DB) runAutoMerge() {
+	defer db.autoMerge.wg.Done()
+
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.closeCh:
+			return
+		case now := <-ticker.C:
+			db.tryAutoMerge(now)
+		}
+	}
+}
+
+// 检查是否满足自动merge的条件，满足时执行merge
+func (db *DB) tryAutoMerge(now time.Time) {
+	am := db.autoMerge
+	am.mu.Lock()
+	am.state.LastCheck = now
+	am.mu.Unlock()
+
+	if !inMergeWindow(now, db.options.AutoMergeWindowStart, db.options.AutoMergeWindowEnd) {
+		return
+	}
+	if reached, err := db.autoMergeRatioReached(); err != nil || !reached {
+		return
+	}
+
+	am.mu.Lock()
+	am.state.Running = true
+	am.mu.Unlock()
+
+	err := db.Merge()
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.state.Running = false
+	switch err {
+	case nil:
+		am.state.Merges++
+		am.state.LastMerge = time.Now()
+		am.state.LastError = nil
+	case ErrMergeRatioUnreached, ErrMergeIsProcess:
+		// 条件暂时不满足，等待下次检查
+	default:
+		am.state.LastError = err
+	}
+}
+
+// 无效数据的比例是否达到阈值
+// 已经merge过的数据在重启之前仍然保留在数据目录中，不再重复计算
+func (db *DB) autoMergeRatioReached() (bool, error) {
+	db.mu.RLock()
+	reclaimSize := db.reclaimSize - db.mergedReclaimSize
+	blobSize := db.blobDiskSize()
+	db.mu.RUnlock()
+	if reclaimSize <= 0 {
+		return false, nil
+	}
+
+	// blob文件不参与merge
+	totalSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return false, err
+	}
+	totalSize -= blobSize
+	if totalSize <= 0 {
+		return false, nil
+	}
+	return float32(reclaimSize)/float32(totalSize) >= db.options.DataFileMergeRatio, nil
+}
+
+// 判断当前时间是否在允许merge的时间段内，start 和 end 为相对于0点的时间
+func inMergeWindow(now time.Time, start, end time.Duration) bool {
+	if start == end {
+		return true
+	}
+
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	if start < end {
+		return offset >= start && offset < end
+	}
+	// 跨越0点
+	return offset >= start || offset < end
+}
+
+// merge限速，按照已经读写的字节数计算需要等待的时间
+type mergeThrottle struct {
+	bytesPerSecond int64
+	start          time.Time
+	bytes          int64
+}
+
+func newMergeThrottle(bytesPerSecond int64) *mergeThrottle {
+	return &mergeThrottle{bytesPerSecond: bytesPerSecond, start: time.Now()}
+}
+
+// 记录读写的字节数，超过速率上限时等待，数据库关闭时返回 ErrMergeAborted
+func (mt *mergeThrottle) wait(n int64, stop <-chan struct{}) error {
+	select {
+	case <-stop:
+		return ErrMergeAborted
+	default:
+	}
+	if mt.bytesPerSecond <= 0 {
+		return nil
+	}
+
+	mt.bytes += n
+	expected := time.Duration(float64(mt.bytes) / float64(mt.bytesPerSecond) * float64(time.Second))
+	delay := expected - time.Since(mt.start)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-stop:
+		return ErrMergeAborted
+	}
+}
@@ -0,0 +1,134 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0.3
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.False(t, db.Stat().AutoMerge.Enabled)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 800; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 重新打开后由后台自动merge
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().AutoMerge.Enabled)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for db.Stat().AutoMerge.Merges == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stat := db.Stat().AutoMerge
+	assert.Equal(t, uint64(1), stat.Merges)
+	assert.False(t, stat.LastMerge.IsZero())
+	assert.Nil(t, stat.LastError)
+
+	// 已经merge过的无效数据不会再次触发merge
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uint64(1), db.Stat().AutoMerge.Merges)
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(db2.ListKeys()))
+}
+
+func TestDB_MergeThrottle(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-throttle")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeBytesPerSecond = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+
+	// 读写各约200KB，限速1MB/s时至少需要约400ms
+	start := time.Now()
+	assert.Nil(t, db.Merge())
+	assert.True(t, time.Since(start) > 300*time.Millisecond)
+}
+
+func TestInMergeWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2023, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	assert.True(t, inMergeWindow(at(12, 0), 0, 0))
+	assert.True(t, inMergeWindow(at(2, 30), 2*time.Hour, 4*time.Hour))
+	assert.False(t, inMergeWindow(at(4, 0), 2*time.Hour, 4*time.Hour))
+	assert.False(t, inMergeWindow(at(1, 59), 2*time.Hour, 4*time.Hour))
+
+	// 跨越0点
+	assert.True(t, inMergeWindow(at(23, 0), 22*time.Hour, 2*time.Hour))
+	assert.True(t, inMergeWindow(at(1, 0), 22*time.Hour, 2*time.Hour))
+	assert.False(t, inMergeWindow(at(12, 0), 22*time.Hour, 2*time.Hour))
+}
+
+func TestDB_MergeReleasesFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-release")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	defer os.RemoveAll(db.getMergePath())
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// merge完成之后merge目录的文件锁已经释放，可以再次merge
+	assert.Nil(t, db.Merge())
+	fileLock := flock.New(filepath.Join(db.getMergePath(), fileLockName))
+	hold, err := fileLock.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, hold)
+	assert.Nil(t, fileLock.Unlock())
+	assert.Nil(t, db.Merge())
+
+	// 读取旧数据文件失败时merge返回错误
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 16*1024)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, data.ErrInvalidCRC, db.Merge())
+
+	// 失败的merge同样释放了文件锁
+	hold, err = fileLock.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, hold)
+	assert.Nil(t, fileLock.Unlock())
+}
@@ -0,0 +1,411 @@
+package bitcask_go
+
+import (
+	"archive/tar"
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const backupManifestFileName = "backup-manifest"
+
+// 备份中的一个文件
+type BackupFile struct {
+	Name     string // 文件名
+	FileId   uint32 // 数据文件和blob文件的id，其他文件为0
+	Size     int64  // 文件大小
+	Checksum uint32 // 文件内容的crc32校验值
+	Stored   bool   // 是否保存在本次备份的目录中，否则保存在之前的某次备份中
+}
+
+// 备份清单，记录恢复一次备份需要的全部文件
+type Manifest struct {
+	NonMergeFileId uint32       // 备份时最近一次merge的非merge文件id
+	Files          []BackupFile // 恢复需要的全部文件
+}
+
+// 读取备份目录中的清单
+func LoadManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	buf, err := os.ReadFile(filepath.Join(dir, backupManifestFileName))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+// 增量备份到目录 dir，since 为之前某次备份的清单，为空时执行全量备份
+// 数据文件和blob文件切换之后不再修改，since 中已经备份过并且没有被merge重写的文件不再拷贝
+// 只有切换活跃文件时持有锁，拷贝文件时不阻塞读写
+func (db *DB) BackupIncremental(dir string, since Manifest) (Manifest, error) {
+	manifest := Manifest{NonMergeFileId: db.nonMergeFileId}
+	if db.options.ReadOnly {
+		return manifest, ErrReadOnly
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return manifest, ErrBackupDirNotEmpty
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return manifest, err
+	}
+
+	var indexTx *bbolt.Tx
+	files, err := db.rotateActiveFile(func(seqNo uint64) error {
+		var err error
+		indexTx, err = db.copyMutableFiles(dir, seqNo)
+		return err
+	})
+	defer db.unpinBlobFiles()
+	if err != nil {
+		return manifest, err
+	}
+	if err := copyIndexFile(indexTx, dir); err != nil {
+		return manifest, err
+	}
+
+	previous := make(map[string]BackupFile, len(since.Files))
+	for _, file := range since.Files {
+		previous[file.Name] = file
+	}
+
+	// 不再修改的数据文件和blob文件，reusable 表示 since 中相同名称和大小的文件可以直接复用
+	// merge之后小于 nonMergeFileId 的数据文件被重写，id相同的文件内容可能不同
+	// blob文件不会被merge重写，文件id也不会重复使用
+	type immutableFile struct {
+		path     string
+		fid      uint32
+		reusable bool
+	}
+	srcFiles := make([]immutableFile, 0, len(files.fileIds)+len(files.blobFileIds))
+	for _, fid := range files.fileIds {
+		srcFiles = append(srcFiles, immutableFile{
+			path:     data.GetDataFileName(db.options.DirPath, fid),
+			fid:      fid,
+			reusable: since.NonMergeFileId == db.nonMergeFileId || fid >= db.nonMergeFileId,
+		})
+	}
+	for _, fid := range files.blobFileIds {
+		srcFiles = append(srcFiles, immutableFile{
+			path:     data.GetBlobFileName(db.options.DirPath, fid),
+			fid:      fid,
+			reusable: true,
+		})
+	}
+
+	for _, srcFile := range srcFiles {
+		name := filepath.Base(srcFile.path)
+		info, err := os.Stat(srcFile.path)
+		if err != nil {
+			return manifest, err
+		}
+
+		if prev, ok := previous[name]; ok && prev.Size == info.Size() && srcFile.reusable {
+			prev.Stored = false
+			manifest.Files = append(manifest.Files, prev)
+			continue
+		}
+
+		file, err := copyBackupFile(srcFile.path, filepath.Join(dir, name))
+		if err != nil {
+			return manifest, err
+		}
+		file.FileId = srcFile.fid
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// hint-index 等文件每次都保存
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		src := filepath.Join(db.options.DirPath, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		file, err := copyBackupFile(src, filepath.Join(dir, name))
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// 事务序列号文件已经在持有锁时写入备份目录，b+树索引文件从切换时开启的只读事务中拷贝
+	for _, name := range []string{data.SeqNoFileName, index.BPTreeIndexFileName} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		file, err := copyBackupFile(path, "")
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	return manifest, writeManifest(dir, manifest)
+}
+
+// 按顺序传入全量备份和之后的增量备份目录，使用最后一次备份的清单在 target 中恢复完整的数据目录
+// 每个文件从最近一次保存了它的备份中拷贝，并校验大小和校验值
+func Restore(backupDirs []string, target string) error {
+	if len(backupDirs) == 0 {
+		return ErrBackupFileMissing
+	}
+	if entries, err := os.ReadDir(target); err == nil && len(entries) > 0 {
+		return ErrRestoreTargetNotEmpty
+	}
+
+	manifests := make([]Manifest, len(backupDirs))
+	for i, dir := range backupDirs {
+		manifest, err := LoadManifest(dir)
+		if err != nil {
+			return err
+		}
+		manifests[i] = manifest
+	}
+
+	if err := os.MkdirAll(target, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range manifests[len(manifests)-1].Files {
+		dir := findBackupFile(backupDirs, manifests, file)
+		if dir == "" {
+			return ErrBackupFileMissing
+		}
+		restored, err := copyBackupFile(filepath.Join(dir, file.Name), filepath.Join(target, file.Name))
+		if err != nil {
+			return err
+		}
+		if restored.Size != file.Size || restored.Checksum != file.Checksum {
+			return ErrBackupCorrupted
+		}
+	}
+	return nil
+}
+
+// 从后向前查找保存了文件的备份目录
+func findBackupFile(backupDirs []string, manifests []Manifest, file BackupFile) string {
+	for i := len(manifests) - 1; i >= 0; i-- {
+		for _, f := range manifests[i].Files {
+			if f.Stored && f.Name == file.Name && f.Size == file.Size && f.Checksum == file.Checksum {
+				return backupDirs[i]
+			}
+		}
+	}
+	return ""
+}
+
+// 拷贝文件并计算大小和校验值，dest 为空时只计算校验值
+func copyBackupFile(src, dest string) (BackupFile, error) {
+	file := BackupFile{Name: filepath.Base(src), Stored: true}
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return file, err
+	}
+	defer srcFile.Close()
+
+	hash := crc32.NewIEEE()
+	var w io.Writer = hash
+	var destFile *os.File
+	if dest != "" {
+		destFile, err = os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return file, err
+		}
+		defer destFile.Close()
+		w = io.MultiWriter(destFile, hash)
+	}
+
+	if file.Size, err = io.Copy(w, srcFile); err != nil {
+		return file, err
+	}
+	file.Checksum = hash.Sum32()
+	if destFile != nil {
+		return file, destFile.Sync()
+	}
+	return file, nil
+}
+
+// 写入备份清单
+func writeManifest(dir string, manifest Manifest) error {
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestFile, err := os.OpenFile(filepath.Join(dir, backupManifestFileName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer manifestFile.Close()
+	if _, err := manifestFile.Write(buf); err != nil {
+		return err
+	}
+	return manifestFile.Sync()
+}
+
+// 将数据目录的一致性快照以tar格式写入 w，不在磁盘上暂存
+// 只有切换活跃文件时持有锁，写入 w 时不阻塞读写
+// b+树索引文件会随写入修改，持有锁时开启只读事务，释放锁之后从事务中写入 w，
+// 写入完成之前需要扩大索引文件内存映射的写入会等待
+func (db *DB) BackupTo(w io.Writer) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	var seqNoRecord []byte
+	var indexTx *bbolt.Tx
+	files, err := db.rotateActiveFile(func(seqNo uint64) error {
+		encRecord, err := db.encodeSeqNoRecord(seqNo)
+		if err != nil {
+			return err
+		}
+		seqNoRecord = encRecord
+		indexTx, err = db.beginIndexCopy()
+		return err
+	})
+	defer db.unpinBlobFiles()
+	if indexTx != nil {
+		defer indexTx.Rollback()
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeTarBytes(tw, data.SeqNoFileName, seqNoRecord); err != nil {
+		return err
+	}
+	if indexTx != nil {
+		if err := writeTarIndex(tw, indexTx); err != nil {
+			return err
+		}
+	}
+
+	// 切换之后的旧数据文件和blob文件在重启之前不会再被修改
+	for _, fid := range files.fileIds {
+		if err := writeTarFile(tw, data.GetDataFileName(db.options.DirPath, fid)); err != nil {
+			return err
+		}
+	}
+	for _, fid := range files.blobFileIds {
+		if err := writeTarFile(tw, data.GetBlobFileName(db.options.DirPath, fid)); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		path := filepath.Join(db.options.DirPath, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := writeTarFile(tw, path); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 从 BackupTo 生成的tar中恢复数据目录到 dir
+func RestoreFrom(r io.Reader, dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return ErrRestoreTargetNotEmpty
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// 只接受数据目录中的普通文件，避免写入 dir 之外的路径
+		if header.Typeflag != tar.TypeReg || header.Name != filepath.Base(header.Name) ||
+			header.Name == "." || header.Name == ".." {
+			return ErrInvalidBackupArchive
+		}
+		if err := restoreTarFile(tr, filepath.Join(dir, header.Name)); err != nil {
+			return err
+		}
+	}
+}
+
+// 将文件写入tar
+func writeTarFile(tw *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.Base(path),
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, file, info.Size())
+	return err
+}
+
+// 将b+树索引在只读事务中的视图作为文件写入tar
+func writeTarIndex(tw *tar.Writer, tx *bbolt.Tx) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     index.BPTreeIndexFileName,
+		Mode:     0644,
+		Size:     tx.Size(),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tx.WriteTo(tw)
+	return err
+}
+
+// 将内存中的数据作为文件写入tar
+func writeTarBytes(tw *tar.Writer, name string, buf []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(buf)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(buf)
+	return err
+}
+
+// 将tar中的当前文件写入磁盘
+func restoreTarFile(r io.Reader, path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		return err
+	}
+	return file.Sync()
+}
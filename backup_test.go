@@ -0,0 +1,312 @@
+package bitcask_go
+
+import (
+	"archive/tar"
+	"bitcask-go/utils"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_BackupIncremental(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	fullDir, _ := os.MkdirTemp("", "bitcask-go-backup-full")
+	defer os.RemoveAll(fullDir)
+	full, err := db.BackupIncremental(fullDir, Manifest{})
+	assert.Nil(t, err)
+	for _, file := range full.Files {
+		assert.True(t, file.Stored)
+	}
+
+	for i := 500; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	incDir, _ := os.MkdirTemp("", "bitcask-go-backup-inc")
+	defer os.RemoveAll(incDir)
+	inc, err := db.BackupIncremental(incDir, full)
+	assert.Nil(t, err)
+
+	// 全量备份中的数据文件不再拷贝
+	loaded, err := LoadManifest(incDir)
+	assert.Nil(t, err)
+	assert.Equal(t, inc, loaded)
+	var stored, reused int
+	for _, file := range inc.Files {
+		if file.Stored {
+			stored++
+		} else {
+			reused++
+			_, err := os.Stat(filepath.Join(incDir, file.Name))
+			assert.True(t, os.IsNotExist(err))
+		}
+	}
+	assert.True(t, reused > 0)
+	assert.True(t, stored > 0)
+
+	// 缺少全量备份时无法恢复
+	target, _ := os.MkdirTemp("", "bitcask-go-restore")
+	defer os.RemoveAll(target)
+	assert.Equal(t, ErrBackupFileMissing, Restore([]string{incDir}, target))
+	os.RemoveAll(target)
+
+	assert.Nil(t, Restore([]string{fullDir, incDir}, target))
+	assert.Equal(t, ErrRestoreTargetNotEmpty, Restore([]string{fullDir, incDir}, target))
+
+	restoreOpts := opts
+	restoreOpts.DirPath = target
+	restored, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	defer restored.Close()
+	assert.Equal(t, 599, len(restored.ListKeys()))
+	_, err = restored.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for _, i := range []int{1, 499, 599} {
+		expected, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		val, err := restored.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
+
+func TestDB_BackupIncremental_AfterMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	fullDir, _ := os.MkdirTemp("", "bitcask-go-backup-full")
+	defer os.RemoveAll(fullDir)
+	full, err := db.BackupIncremental(fullDir, Manifest{})
+	assert.Nil(t, err)
+
+	// merge重写数据文件，重启之后生效
+	for i := 0; i < 800; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("after merge")))
+
+	incDir, _ := os.MkdirTemp("", "bitcask-go-backup-inc")
+	defer os.RemoveAll(incDir)
+	inc, err := db.BackupIncremental(incDir, full)
+	assert.Nil(t, err)
+	assert.NotEqual(t, full.NonMergeFileId, inc.NonMergeFileId)
+
+	target, _ := os.MkdirTemp("", "bitcask-go-restore")
+	defer os.RemoveAll(target)
+	assert.Nil(t, Restore([]string{fullDir, incDir}, target))
+
+	restoreOpts := opts
+	restoreOpts.DirPath = target
+	restored, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	defer restored.Close()
+	assert.Equal(t, 201, len(restored.ListKeys()))
+	val, err := restored.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after merge"), val)
+	_, err = restored.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestRestore_Corrupted(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-corrupted")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-full")
+	defer os.RemoveAll(backupDir)
+	manifest, err := db.BackupIncremental(backupDir, Manifest{})
+	assert.Nil(t, err)
+
+	// 修改备份中的数据文件
+	f, err := os.OpenFile(filepath.Join(backupDir, manifest.Files[0].Name), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 10)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	target, _ := os.MkdirTemp("", "bitcask-go-restore")
+	defer os.RemoveAll(target)
+	assert.Equal(t, ErrBackupCorrupted, Restore([]string{backupDir}, target))
+}
+
+func TestDB_BackupTo(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-tar")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	// 通过管道传输，备份期间继续写入
+	values := make([][]byte, 100)
+	for i := range values {
+		values[i] = utils.RandomValue(128)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(db.BackupTo(pw))
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i, value := range values {
+			assert.Nil(t, db.Put(utils.GetTestKey(500+i), value))
+		}
+	}()
+
+	target, _ := os.MkdirTemp("", "bitcask-go-restore")
+	defer os.RemoveAll(target)
+	assert.Nil(t, RestoreFrom(pr, target))
+	<-done
+	assert.Equal(t, ErrRestoreTargetNotEmpty, RestoreFrom(bytes.NewReader(nil), target))
+
+	restoreOpts := opts
+	restoreOpts.DirPath = target
+	restored, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	defer restored.Close()
+	keys := restored.ListKeys()
+	assert.True(t, len(keys) >= 499)
+	_, err = restored.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for _, i := range []int{1, 250, 499} {
+		expected, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		val, err := restored.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
+
+func TestRestoreFrom_InvalidArchive(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("bitcask")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "../000000000.data",
+		Mode:     0644,
+		Size:     int64(len(content)),
+	}))
+	_, err := tw.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+
+	// 恢复目录放在单独的父目录中，检查父目录中没有写入文件
+	parent, _ := os.MkdirTemp("", "bitcask-go-restore")
+	defer os.RemoveAll(parent)
+	target := filepath.Join(parent, "target")
+	assert.Equal(t, ErrInvalidBackupArchive, RestoreFrom(&buf, target))
+	_, err = os.Stat(filepath.Join(parent, "000000000.data"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_BackupTo_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-tar-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(db.BackupTo(pw))
+	}()
+
+	// 读取第一个文件的头部之后暂停读取，备份阻塞在写入时不持有数据库的锁
+	var head bytes.Buffer
+	_, err = tar.NewReader(io.TeeReader(pr, &head)).Next()
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Nil(t, db.Sync())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		go io.Copy(io.Discard, pr)
+		t.Fatal("db is locked by the backup writer")
+	}
+
+	// 写入需要扩大索引文件的内存映射时等待只读事务结束，不影响备份的内容
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		assert.Nil(t, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+	}()
+
+	target, _ := os.MkdirTemp("", "bitcask-go-restore")
+	defer os.RemoveAll(target)
+	assert.Nil(t, RestoreFrom(io.MultiReader(&head, pr), target))
+
+	<-written
+
+	restoreOpts := opts
+	restoreOpts.DirPath = target
+	restored, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	defer restored.Close()
+	_, err = restored.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for _, i := range []int{0, 50, 99} {
+		expected, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		val, err := restored.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
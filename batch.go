@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const nonTransactionSeqNo uint64 = 0
@@ -15,9 +16,59 @@ type WriteBatch struct {
 	options       WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	pendingWrites *writeSet // 暂存用户写入的数据
 }
 
+// 按写入顺序暂存的数据，同一个key再次写入时替换之前的记录并移到最后，提交时按写入顺序写入日志
+type writeSet struct {
+	records []*data.LogRecord // 按写入顺序保存，被替换或删除的位置为nil
+	index   map[string]int    // key -> records 中的下标
+}
+
+func newWriteSet() *writeSet {
+	return &writeSet{index: make(map[string]int)}
+}
+
+// 暂存一条记录，替换之前同一个key的记录
+func (ws *writeSet) put(record *data.LogRecord) {
+	ws.remove(record.Key)
+	ws.index[string(record.Key)] = len(ws.records)
+	ws.records = append(ws.records, record)
+}
+
+// 获取key暂存的记录，不存在时返回nil
+func (ws *writeSet) get(key []byte) *data.LogRecord {
+	if i, ok := ws.index[string(key)]; ok {
+		return ws.records[i]
+	}
+	return nil
+}
+
+// 删除key暂存的记录
+func (ws *writeSet) remove(key []byte) {
+	if i, ok := ws.index[string(key)]; ok {
+		ws.records[i] = nil
+		delete(ws.index, string(key))
+	}
+}
+
+// 暂存的key数量
+func (ws *writeSet) len() int {
+	return len(ws.index)
+}
+
+// 按写入顺序返回暂存的记录
+func (ws *writeSet) ordered() []*data.LogRecord {
+	records := make([]*data.LogRecord, 0, len(ws.index))
+	for _, record := range ws.records {
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// 只读模式下批量写入的操作返回 ErrReadOnly
 func (db *DB) NewWriteBatch(options WriteBatchOptions) *WriteBatch {
 	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
 		panic("cannot create write batch without sequence number file")
@@ -26,21 +77,29 @@ func (db *DB) NewWriteBatch(options WriteBatchOptions) *WriteBatch {
 		options:       options,
 		mu:            new(sync.Mutex),
 		db:            db,
-		pendingWrites: make(map[string]*data.LogRecord),
+		pendingWrites: newWriteSet(),
 	}
 }
 
 // 批量写入数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.PutWithTTL(key, value, 0)
+}
+
+// 批量写入带过期时间的数据，ttl <= 0 表示永不过期，过期时间从调用时开始计算
+func (wb *WriteBatch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Value: value, ExpiresAt: expiresAtFromTTL(ttl)}
+	wb.pendingWrites.put(logRecord)
 
 	return nil
 }
@@ -50,20 +109,21 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	logRecordPos := wb.db.index.Get(key)
 	if logRecordPos == nil {
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
-		}
+		wb.pendingWrites.remove(key)
 		return nil
 	}
 
 	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites.put(logRecord)
 
 	return nil
 }
@@ -73,34 +133,47 @@ func (wb *WriteBatch) Commit() error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	if len(wb.pendingWrites) == 0 {
+	if wb.pendingWrites.len() == 0 {
 		return nil
 	}
 
-	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
+	if uint(wb.pendingWrites.len()) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 加锁保证事务提交串行化
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	// 加锁保证事务提交串行化，持久化通过组提交与其他写入合并
+	syncWrites := wb.options.SyncWrites || wb.db.options.SyncWrites
+	if err := wb.db.writeWithSync(syncWrites, func() error {
+		return wb.db.commitPendingWrites(wb.pendingWrites.ordered())
+	}); err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = newWriteSet()
 
+	return nil
+}
+
+// 以事务的方式将暂存的数据按顺序写到数据文件，并更新内存索引，变更事件与日志中的顺序一致，调用方需要持有db.mu
+func (db *DB) commitPendingWrites(records []*data.LogRecord) error {
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// 写入数据文件
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+	positions := make([]*data.LogRecordPos, 0, len(records))
+	for _, record := range records {
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(record.Key, seqNo),
+			Value:     record.Value,
+			Type:      record.Type,
+			ExpiresAt: record.ExpiresAt,
 		})
 
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions = append(positions, logRecordPos)
 	}
 
 	// 写一条标记事务完成的数据
@@ -108,33 +181,29 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	if _, err := db.appendLogRecord(finishedRecord); err != nil {
 		return err
 	}
 
-	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.IoManager.Sync(); err != nil {
-			return err
-		}
-	}
-
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
+	var events []*WatchEvent
+	for i, record := range records {
+		pos := positions[i]
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = db.index.Put(record.Key, pos)
 		} else if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _ = db.index.Delete(record.Key)
 		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+		db.discardPos(oldPos)
+
+		if event := db.newWatchEvent(watchEventTypeOf(record.Type), record.Key, record.Value, seqNo); event != nil {
+			events = append(events, event)
 		}
 	}
-
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+	if len(events) > 0 {
+		db.notifyWatchers(events)
+	}
 
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"bitcask-go/utils"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -88,3 +89,30 @@ func TestDB_WriteBatch2(t *testing.T) {
 
 	assert.Equal(t, uint64(2), db.seqNo)
 }
+
+func TestDB_WriteBatchTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 50*time.Millisecond)
+	assert.Nil(t, err)
+	err = wb.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"bitcask-go/utils"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -64,3 +65,49 @@ func Benchmark_Delete(b *testing.B) {
 		assert.Nil(b, err)
 	}
 }
+
+// 同步写入时，并发写入者通过组提交共享持久化操作
+func openSyncDB(b *testing.B) *bitcask.DB {
+	options := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bench-sync")
+	options.DirPath = dir
+	options.SyncWrites = true
+
+	syncDB, err := bitcask.Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = syncDB.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return syncDB
+}
+
+func Benchmark_PutSync(b *testing.B) {
+	syncDB := openSyncDB(b)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		err := syncDB.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+}
+
+func Benchmark_PutSyncParallel(b *testing.B) {
+	syncDB := openSyncDB(b)
+	value := utils.RandomValue(1024)
+	var counter int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&counter, 1)
+			err := syncDB.Put(utils.GetTestKey(int(i)), value)
+			assert.Nil(b, err)
+		}
+	})
+}
@@ -0,0 +1,410 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// 大value分离存储
+// 超过 ValueThreshold 的value写入单独的blob文件，数据文件中只保存指向blob记录的指针记录，
+// merge只复制指针记录，blob文件中的无效数据由 CompactBlobs 单独回收
+
+// 是否需要将value写入blob文件
+func (db *DB) isLargeValue(logRecord *data.LogRecord) bool {
+	return db.options.ValueThreshold > 0 &&
+		logRecord.Type == data.LogRecordNormal &&
+		logRecord.Flags&data.LogRecordBlob == 0 &&
+		len(logRecord.Value) > db.options.ValueThreshold
+}
+
+// 将value写入活跃blob文件，返回value替换为blob记录位置的指针记录，调用方需要持有db.mu
+func (db *DB) separateValue(logRecord *data.LogRecord) (*data.LogRecord, *data.LogRecordPos, error) {
+	// blob记录保存不带序列号的key，压缩和加密与普通记录相同
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	blobRecord, err := db.compressLogRecord(&data.LogRecord{
+		Key:       realKey,
+		Value:     logRecord.Value,
+		Type:      data.LogRecordNormal,
+		ExpiresAt: logRecord.ExpiresAt,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if blobRecord, err = db.encryptLogRecord(blobRecord); err != nil {
+		return nil, nil, err
+	}
+	encRecord, size := data.EncodeLogRecord(blobRecord)
+
+	if db.activeBlobFile == nil {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if db.activeBlobFile.WriteOff+size > db.options.DataFileSize && db.activeBlobFile.WriteOff > 0 {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return nil, nil, err
+		}
+		db.olderBlobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, nil, err
+	}
+	db.bytesWrite += uint(size)
+
+	blobPos := &data.LogRecordPos{
+		Fid:       db.activeBlobFile.FileId,
+		Offset:    writeOff,
+		Size:      uint32(size),
+		ExpiresAt: logRecord.ExpiresAt,
+	}
+	record := *logRecord
+	record.Value = data.EncodeLogRecordPos(blobPos)
+	record.Flags |= data.LogRecordBlob
+	return &record, blobPos, nil
+}
+
+// 打开新的活跃blob文件，调用方需要持有db.mu
+func (db *DB) setActiveBlobFile() error {
+	var fileId uint32 = 0
+	if db.activeBlobFile != nil {
+		fileId = db.activeBlobFile.FileId + 1
+	}
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, fileId)
+	if err != nil {
+		return err
+	}
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// 读取指针记录指向的blob记录，将value替换为完整的value，调用方需要持有db.mu
+func (db *DB) readBlobValue(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.LogRecordBlob == 0 {
+		return nil
+	}
+
+	blobPos := data.DecodeLogRecordPos(logRecord.Value)
+	blobFile := db.blobFile(blobPos.Fid)
+	if blobFile == nil {
+		return ErrDataFileNotFound
+	}
+	blobRecord, _, err := db.readLogRecord(blobFile, blobPos.Offset)
+	if err != nil {
+		return err
+	}
+	logRecord.Value = blobRecord.Value
+	logRecord.Flags &^= data.LogRecordBlob
+	return nil
+}
+
+func (db *DB) blobFile(fid uint32) *data.DataFile {
+	if db.activeBlobFile != nil && db.activeBlobFile.FileId == fid {
+		return db.activeBlobFile
+	}
+	return db.olderBlobFiles[fid]
+}
+
+// 打开目录中还没有打开的blob文件，最后一个文件作为活跃blob文件，调用方需要持有db.mu
+func (db *DB) loadBlobFiles() error {
+	fileIds, err := listFileIds(db.options.DirPath, data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, fid := range fileIds {
+		fileId := uint32(fid)
+		if db.blobFile(fileId) != nil {
+			continue
+		}
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, fileId)
+		if err != nil {
+			return err
+		}
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = size
+
+		if db.activeBlobFile != nil {
+			db.olderBlobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		}
+		db.activeBlobFile = blobFile
+	}
+	return nil
+}
+
+// 记录指针记录引用的blob记录
+func (db *DB) addBlobRef(pos *data.LogRecordPos, blobPos *data.LogRecordPos) {
+	db.blobRefs[newOperandKey(pos)] = blobPos
+}
+
+// 指针记录被覆盖或删除之后，它引用的blob记录成为无效数据
+func (db *DB) removeBlobRef(pos *data.LogRecordPos) {
+	delete(db.blobRefs, newOperandKey(pos))
+}
+
+// 统计每个blob文件的大小和其中有效数据的大小，调用方需要持有db.mu
+func (db *DB) blobFileSizes() (map[uint32]int64, map[uint32]int64) {
+	totalSizes := make(map[uint32]int64, len(db.olderBlobFiles)+1)
+	for fid, blobFile := range db.olderBlobFiles {
+		totalSizes[fid] = blobFile.WriteOff
+	}
+	if db.activeBlobFile != nil {
+		totalSizes[db.activeBlobFile.FileId] = db.activeBlobFile.WriteOff
+	}
+
+	liveSizes := make(map[uint32]int64, len(totalSizes))
+	for _, blobPos := range db.blobRefs {
+		liveSizes[blobPos.Fid] += int64(blobPos.Size)
+	}
+	return totalSizes, liveSizes
+}
+
+// blob文件中可以回收的数据大小，调用方需要持有db.mu
+func (db *DB) blobReclaimableSize() int64 {
+	totalSizes, liveSizes := db.blobFileSizes()
+	var size int64
+	for fid, total := range totalSizes {
+		if total > liveSizes[fid] {
+			size += total - liveSizes[fid]
+		}
+	}
+	return size
+}
+
+// 所有blob文件的大小，调用方需要持有db.mu
+func (db *DB) blobDiskSize() int64 {
+	totalSizes, _ := db.blobFileSizes()
+	var size int64
+	for _, total := range totalSizes {
+		size += total
+	}
+	return size
+}
+
+// 待压缩的blob文件中的一条有效数据
+type blobRef struct {
+	pos     *data.LogRecordPos // 指针记录的位置
+	blobPos *data.LogRecordPos // blob记录的位置
+}
+
+// 压缩blob文件
+// 无效数据比例达到 BlobMergeRatio 的旧blob文件中仍然有效的value重新写入活跃blob文件，并追加新的指针记录，
+// 之后删除旧的blob文件，旧的指针记录由merge回收
+// 存在只读实例、未释放的快照或者正在进行的检查点和备份时保留旧的blob文件，之后再次压缩时删除
+func (db *DB) CompactBlobs() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProcess
+	}
+
+	totalSizes, liveSizes := db.blobFileSizes()
+	candidates := make(map[uint32][]blobRef)
+	for fid := range db.olderBlobFiles {
+		total := totalSizes[fid]
+		if total == 0 || float32(total-liveSizes[fid])/float32(total) >= db.options.BlobMergeRatio {
+			candidates[fid] = nil
+		}
+	}
+	if len(candidates) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	for key, blobPos := range db.blobRefs {
+		if _, ok := candidates[blobPos.Fid]; ok {
+			pos := &data.LogRecordPos{Fid: key.fid, Offset: key.offset}
+			candidates[blobPos.Fid] = append(candidates[blobPos.Fid], blobRef{pos: pos, blobPos: blobPos})
+		}
+	}
+
+	db.isMerging = true
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	fileIds := make([]uint32, 0, len(candidates))
+	for fid := range candidates {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	for _, fid := range fileIds {
+		for _, ref := range candidates[fid] {
+			if err := db.relocateBlob(ref); err != nil {
+				return err
+			}
+		}
+	}
+	return db.removeBlobFiles(fileIds)
+}
+
+// 将指针记录仍然有效的blob记录重新写入活跃blob文件，并追加新的指针记录，否则只删除引用
+// 操作数链以该指针记录开头时，写入合并之后的完整值
+func (db *DB) relocateBlob(ref blobRef) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 指针记录已经被覆盖或删除
+	if _, ok := db.blobRefs[newOperandKey(ref.pos)]; !ok {
+		return nil
+	}
+	blobFile := db.blobFile(ref.blobPos.Fid)
+	if blobFile == nil {
+		return ErrDataFileNotFound
+	}
+	blobRecord, _, err := db.readLogRecord(blobFile, ref.blobPos.Offset)
+	if err != nil {
+		return err
+	}
+	key := blobRecord.Key
+
+	curPos := db.index.Get(key)
+	if curPos == nil || curPos.IsExpired(time.Now().UnixNano()) || !db.referencesPos(curPos, ref.pos) {
+		db.removeBlobRef(ref.pos)
+		return nil
+	}
+
+	value, err := db.getValueByPosition(curPos)
+	if err != nil {
+		return err
+	}
+	// 值没有变化，变更流跳过这条记录
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Flags:     data.LogRecordRelocated,
+		ExpiresAt: curPos.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	db.discardPos(db.index.Put(key, pos))
+	// 旧的引用已经在 discardPos 中删除，这里保证引用不会残留
+	db.removeBlobRef(ref.pos)
+	return nil
+}
+
+// 索引位置 curPos 是否就是 pos，或者以 pos 开头的操作数链
+func (db *DB) referencesPos(curPos *data.LogRecordPos, pos *data.LogRecordPos) bool {
+	if curPos.Fid == pos.Fid && curPos.Offset == pos.Offset {
+		return true
+	}
+	for _, chainPos := range db.operands[newOperandKey(curPos)] {
+		if chainPos.Fid == pos.Fid && chainPos.Offset == pos.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// 持久化移动之后的数据，删除不再被引用的blob文件
+func (db *DB) removeBlobFiles(fileIds []uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.syncBlobFile(); err != nil {
+		return err
+	}
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if len(fileIds) == 0 {
+		return nil
+	}
+
+	// 压缩期间创建的快照可能引用旧的blob文件，检查点和备份可能正在拷贝旧的blob文件
+	if len(db.snapshots) > 0 || db.blobFilePins > 0 {
+		return nil
+	}
+	// 只读实例可能正在读取旧的blob文件
+	readerLock := flock.New(filepath.Join(db.options.DirPath, readerLockName))
+	hold, err := readerLock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !hold {
+		return nil
+	}
+	defer func() {
+		_ = readerLock.Unlock()
+	}()
+
+	for _, fid := range fileIds {
+		blobFile := db.olderBlobFiles[fid]
+		if blobFile == nil {
+			continue
+		}
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+		delete(db.olderBlobFiles, fid)
+		if err := os.Remove(data.GetBlobFileName(db.options.DirPath, fid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 持久化活跃blob文件
+func (db *DB) syncBlobFile() error {
+	if db.activeBlobFile == nil {
+		return nil
+	}
+	return db.activeBlobFile.Sync()
+}
+
+// 关闭所有blob文件
+func (db *DB) closeBlobFiles() error {
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Close(); err != nil {
+			return err
+		}
+	}
+	for _, blobFile := range db.olderBlobFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 编码引用blob记录的hint索引，格式为 指针记录位置的长度 + 指针记录位置 + blob记录位置
+func encodeBlobHint(pos *data.LogRecordPos, blobPos *data.LogRecordPos) []byte {
+	posBuf := data.EncodeLogRecordPos(pos)
+	buf := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32+len(posBuf)*2)
+	buf = buf[:binary.PutUvarint(buf, uint64(len(posBuf)))]
+	buf = append(buf, posBuf...)
+	return append(buf, data.EncodeLogRecordPos(blobPos)...)
+}
+
+func decodeBlobHint(buf []byte) (*data.LogRecordPos, *data.LogRecordPos) {
+	size, n := binary.Uvarint(buf)
+	pos := data.DecodeLogRecordPos(buf[n : n+int(size)])
+	blobPos := data.DecodeLogRecordPos(buf[n+int(size):])
+	return pos, blobPos
+}
@@ -0,0 +1,185 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 目录中指定后缀的文件的总大小
+func filesSize(t *testing.T, dir string, suffix string) int64 {
+	fileIds, err := listFileIds(dir, suffix)
+	assert.Nil(t, err)
+	var size int64
+	for _, fid := range fileIds {
+		name := data.GetDataFileName(dir, uint32(fid))
+		if suffix == data.BlobFileNameSuffix {
+			name = data.GetBlobFileName(dir, uint32(fid))
+		}
+		info, err := os.Stat(name)
+		assert.Nil(t, err)
+		size += info.Size()
+	}
+	return size
+}
+
+func TestDB_ValueSeparation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 200; i++ {
+		value := utils.RandomValue(1024)
+		if i%2 == 0 {
+			value = utils.RandomValue(16)
+		}
+		values[string(utils.GetTestKey(i))] = value
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+
+	// 数据文件中只保存指针记录
+	stat := db.Stat()
+	assert.True(t, stat.BlobFileNum > 1)
+	assert.True(t, filesSize(t, dir, data.DataFileNameSuffix) < filesSize(t, dir, data.BlobFileNameSuffix)/5)
+
+	check := func(db *DB) {
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		var folded int
+		assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+			assert.Equal(t, values[string(key)], value)
+			folded++
+			return true
+		}))
+		assert.Equal(t, len(values), folded)
+
+		iter := db.NewIterator(DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			val, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, values[string(iter.Key())], val)
+		}
+	}
+	check(db)
+
+	// 重启之后从数据文件中重建引用
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Equal(t, int64(0), db.Stat().BlobReclaimableSize)
+
+	// 覆盖和删除之后blob文件中的数据成为无效数据
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("small")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(3)))
+	assert.True(t, db.Stat().BlobReclaimableSize > 2*1024)
+}
+
+func TestDB_ValueSeparation_MergeAndCompact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.ValueThreshold = 256
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 400; i++ {
+		values[string(utils.GetTestKey(i))] = utils.RandomValue(1024)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[string(utils.GetTestKey(i))]))
+	}
+	// 覆盖前一半的key
+	for i := 0; i < 200; i++ {
+		values[string(utils.GetTestKey(i))] = utils.RandomValue(1024)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[string(utils.GetTestKey(i))]))
+	}
+	blobSize := filesSize(t, dir, data.BlobFileNameSuffix)
+
+	// merge只复制指针记录
+	assert.Nil(t, db.Merge())
+	mergePath := db.getMergePath()
+	assert.Equal(t, int64(0), filesSize(t, mergePath, data.BlobFileNameSuffix))
+	assert.True(t, filesSize(t, mergePath, data.DataFileNameSuffix) < blobSize/10)
+
+	// 重启之后从hint文件中重建引用
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, blobSize, filesSize(t, dir, data.BlobFileNameSuffix))
+	reclaimable := db.Stat().BlobReclaimableSize
+	assert.True(t, reclaimable >= 200*1024)
+
+	// 压缩blob文件回收被覆盖的value
+	assert.Nil(t, db.CompactBlobs())
+	assert.True(t, filesSize(t, dir, data.BlobFileNameSuffix) < blobSize-150*1024)
+	assert.True(t, db.Stat().BlobReclaimableSize < reclaimable)
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, len(db.ListKeys()))
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_ValueSeparation_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-checkpoint")
+	opts.DirPath = dir
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := utils.RandomValue(1024)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+
+	checkpointDir, _ := os.MkdirTemp("", "bitcask-go-blob-checkpoint-dir")
+	defer os.RemoveAll(checkpointDir)
+	assert.Nil(t, db.Checkpoint(checkpointDir))
+	blobInfo, err := os.Stat(data.GetBlobFileName(dir, 0))
+	assert.Nil(t, err)
+
+	// 写入检查点不修改原数据库的blob文件
+	checkpointOpts := opts
+	checkpointOpts.DirPath = checkpointDir
+	checkpoint, err := Open(checkpointOpts)
+	assert.Nil(t, err)
+	defer checkpoint.Close()
+	assert.Nil(t, checkpoint.Put(utils.GetTestKey(10), utils.RandomValue(1024)))
+	val, err := checkpoint.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	info, err := os.Stat(data.GetBlobFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, blobInfo.Size(), info.Size())
+	_, err = os.Stat(filepath.Join(checkpointDir, filepath.Base(data.GetBlobFileName(dir, 1))))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,73 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// 按照配置在索引之前加入布隆过滤器，调用方需要在加载索引之前调用
+// 关闭时保存的过滤器在打开时读取之后删除，异常退出之后没有保存的过滤器，根据索引重建
+// 非只读模式下不论是否开启过滤器都删除保存的过滤器，避免之后使用过期的过滤器
+func (db *DB) initBloomFilter() error {
+	var filter *index.BloomFilter
+	if !db.options.ReadOnly {
+		path := filepath.Join(db.options.DirPath, data.BloomFilterFileName)
+		buf, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			filter = decodeBloomFilterFile(buf, db.options.BloomFilterFalsePositiveRate)
+		}
+	}
+
+	if db.options.BloomFilterFalsePositiveRate <= 0 {
+		return nil
+	}
+	db.bloom = index.NewBloomIndexer(db.index, filter, db.options.BloomFilterFalsePositiveRate)
+	db.index = db.bloom
+	return nil
+}
+
+// 解码保存的过滤器，文件损坏或者误判率与配置不同时返回空
+func decodeBloomFilterFile(buf []byte, fpRate float64) *index.BloomFilter {
+	if len(buf) < crc32.Size {
+		return nil
+	}
+	content := buf[:len(buf)-crc32.Size]
+	if crc32.ChecksumIEEE(content) != binary.LittleEndian.Uint32(buf[len(content):]) {
+		return nil
+	}
+	filter, err := index.DecodeBloomFilter(content)
+	if err != nil || filter.FalsePositiveRate() != fpRate {
+		return nil
+	}
+	return filter
+}
+
+// 保存过滤器，格式为 过滤器 + crc32校验值
+func (db *DB) saveBloomFilter() error {
+	if db.bloom == nil || db.options.ReadOnly {
+		return nil
+	}
+	content := db.bloom.EncodeFilter()
+	buf := binary.LittleEndian.AppendUint32(content, crc32.ChecksumIEEE(content))
+
+	file, err := os.OpenFile(filepath.Join(db.options.DirPath, data.BloomFilterFileName),
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(buf); err != nil {
+		return err
+	}
+	return file.Sync()
+}
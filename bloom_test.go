@@ -0,0 +1,66 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_BloomFilter(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.BloomFilterFalsePositiveRate = 0.01
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+
+	check := func(db *DB) {
+		before := db.Stat().BloomAvoidedProbes
+		for i := 0; i < 1000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		for i := 1000; i < 2000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+		assert.True(t, db.Stat().BloomAvoidedProbes-before > 1900)
+	}
+	check(db)
+
+	// 关闭时保存过滤器，打开之后删除
+	assert.Nil(t, db.Close())
+	bloomFile := filepath.Join(dir, data.BloomFilterFileName)
+	_, err = os.Stat(bloomFile)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(bloomFile)
+	assert.True(t, os.IsNotExist(err))
+	check(db)
+
+	// 保存的过滤器损坏时根据索引重建
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("after reopen")))
+	assert.Nil(t, db.Close())
+	buf, err := os.ReadFile(bloomFile)
+	assert.Nil(t, err)
+	buf[len(buf)/2]++
+	assert.Nil(t, os.WriteFile(bloomFile, buf, 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after reopen"), val)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+}
@@ -0,0 +1,125 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// 每条缓存的记录除了key和value之外的内存开销估计
+const valueCacheEntryOverhead = 96
+
+// 读取过的日志记录的LRU缓存，按照记录在数据文件中的位置查找
+// 数据文件中的记录写入之后不再修改，merge的结果在重启时才生效，位置不会指向不同的记录，
+// 被覆盖或删除的记录由 discardPos 从缓存中删除，释放内存
+type valueCache struct {
+	mu       sync.Mutex
+	capacity int64 // 内存上限
+	size     int64 // 已使用的内存
+	lru      *list.List
+	items    map[operandKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+type valueCacheEntry struct {
+	key       operandKey
+	logRecord *data.LogRecord
+	size      int64
+}
+
+func newValueCache(capacity int64) *valueCache {
+	return &valueCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[operandKey]*list.Element),
+	}
+}
+
+// 查找缓存的记录，返回记录的副本，调用方可以修改
+func (vc *valueCache) get(pos *data.LogRecordPos) (*data.LogRecord, bool) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	elem, ok := vc.items[newOperandKey(pos)]
+	if !ok {
+		atomic.AddUint64(&vc.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&vc.hits, 1)
+	vc.lru.MoveToFront(elem)
+	return cloneLogRecord(elem.Value.(*valueCacheEntry).logRecord), true
+}
+
+// 缓存记录，超过内存上限时淘汰最久没有读取的记录
+func (vc *valueCache) put(pos *data.LogRecordPos, logRecord *data.LogRecord) {
+	size := int64(len(logRecord.Key)+len(logRecord.Value)) + valueCacheEntryOverhead
+	if size > vc.capacity {
+		return
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	key := newOperandKey(pos)
+	if _, ok := vc.items[key]; ok {
+		return
+	}
+	entry := &valueCacheEntry{key: key, logRecord: cloneLogRecord(logRecord), size: size}
+	vc.items[key] = vc.lru.PushFront(entry)
+	vc.size += size
+	for vc.size > vc.capacity {
+		vc.removeElement(vc.lru.Back())
+	}
+}
+
+// 删除位置对应的缓存
+func (vc *valueCache) remove(pos *data.LogRecordPos) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if elem, ok := vc.items[newOperandKey(pos)]; ok {
+		vc.removeElement(elem)
+	}
+}
+
+func (vc *valueCache) removeElement(elem *list.Element) {
+	entry := vc.lru.Remove(elem).(*valueCacheEntry)
+	delete(vc.items, entry.key)
+	vc.size -= entry.size
+}
+
+func (vc *valueCache) stat() (uint64, uint64) {
+	return atomic.LoadUint64(&vc.hits), atomic.LoadUint64(&vc.misses)
+}
+
+func cloneLogRecord(logRecord *data.LogRecord) *data.LogRecord {
+	record := *logRecord
+	record.Key = append([]byte(nil), logRecord.Key...)
+	record.Value = append([]byte(nil), logRecord.Value...)
+	return &record
+}
+
+// 根据位置读取日志记录，开启缓存时优先从缓存中读取
+func (db *DB) readLogRecordAt(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	if db.cache == nil {
+		return db.readLogRecordFromFile(logRecordPos)
+	}
+	if logRecord, ok := db.cache.get(logRecordPos); ok {
+		return logRecord, nil
+	}
+
+	logRecord, err := db.readLogRecordFromFile(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	db.cache.put(logRecordPos, logRecord)
+	return logRecord, nil
+}
+
+// 记录被覆盖或删除之后不会再被读取，从缓存中删除
+func (db *DB) evictCache(pos *data.LogRecordPos) {
+	if db.cache != nil {
+		db.cache.remove(pos)
+	}
+}
@@ -0,0 +1,97 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cache")
+	opts.DirPath = dir
+	opts.ValueCacheSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// 第一次读取未命中，之后命中缓存
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	val2, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+	stat := db.Stat()
+	assert.Equal(t, uint64(1), stat.CacheHits)
+	assert.Equal(t, uint64(1), stat.CacheMisses)
+
+	// 修改返回的value不影响缓存
+	val2[0]++
+	val3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val3)
+
+	// 覆盖和删除之后不会读到缓存中的旧值
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("new value")))
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 缓存的内存不超过上限
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		return true
+	}))
+	assert.True(t, db.cache.size <= opts.ValueCacheSize)
+	assert.True(t, db.cache.lru.Len() < 999)
+}
+
+func TestDB_ValueCache_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cache-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make([][]byte, 500)
+	for i := range values {
+		values[i] = utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	for i := 0; i < 250; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 250; i < 500; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge之后记录的位置改变，重启之后读取的都是新位置的记录
+	assert.Nil(t, db.Merge())
+	for i := 250; i < 500; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 250; i < 500; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.Equal(t, uint64(250), db.Stat().CacheMisses)
+}
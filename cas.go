@@ -0,0 +1,98 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"time"
+)
+
+// 仅当key不存在（或已过期）时写入，key已存在返回 ErrKeyAlreadyExists
+func (db *DB) PutIfAbsent(key []byte, value []byte) error {
+	return db.PutIfAbsentWithTTL(key, value, 0)
+}
+
+// 仅当key不存在（或已过期）时写入带过期时间的数据，ttl <= 0 表示永不过期
+func (db *DB) PutIfAbsentWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	expiresAt := expiresAtFromTTL(ttl)
+	return db.writeWithSync(db.options.SyncWrites, func() error {
+		if _, err := db.getLocked(key); err == nil {
+			return ErrKeyAlreadyExists
+		} else if err != ErrKeyNotFound {
+			return err
+		}
+		return db.put(key, value, expiresAt)
+	})
+}
+
+// 仅当key当前的值等于expected时写入新值，保留key原有的过期时间
+// key不存在返回 ErrKeyNotFound，值不相等返回 ErrValueNotMatch
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) error {
+	return db.compareAndSwap(key, expected, value, 0, true)
+}
+
+// 仅当key当前的值等于expected时写入新值，并重新设置过期时间，ttl <= 0 表示永不过期
+// 值不变时可以用于续约租约
+func (db *DB) CompareAndSwapWithTTL(key []byte, expected []byte, value []byte, ttl time.Duration) error {
+	return db.compareAndSwap(key, expected, value, expiresAtFromTTL(ttl), false)
+}
+
+// keepExpiresAt 为true时沿用key原有的过期时间，否则使用 expiresAt
+func (db *DB) compareAndSwap(key []byte, expected []byte, value []byte, expiresAt int64, keepExpiresAt bool) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	return db.writeWithSync(db.options.SyncWrites, func() error {
+		current, logRecordPos, err := db.getWithPosLocked(key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, expected) {
+			return ErrValueNotMatch
+		}
+		if keepExpiresAt {
+			expiresAt = logRecordPos.ExpiresAt
+		}
+		return db.put(key, value, expiresAt)
+	})
+}
+
+// 仅当key当前的值等于expected时删除
+// key不存在返回 ErrKeyNotFound，值不相等返回 ErrValueNotMatch
+func (db *DB) DeleteIfEqual(key []byte, expected []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	return db.writeWithSync(db.options.SyncWrites, func() error {
+		current, err := db.getLocked(key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, expected) {
+			return ErrValueNotMatch
+		}
+		return db.delete(key)
+	})
+}
+
+// 读取当前索引对应的数据，调用方需要持有db.mu
+func (db *DB) getLocked(key []byte) ([]byte, error) {
+	value, _, err := db.getWithPosLocked(key)
+	return value, err
+}
+
+// 读取当前索引对应的数据及其位置，调用方需要持有db.mu
+func (db *DB) getWithPosLocked(key []byte) ([]byte, *data.LogRecordPos, error) {
+	// 从内存索引中查找，过期的数据视为不存在
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, nil, ErrKeyNotFound
+	}
+	value, err := db.getValueByPosition(logRecordPos)
+	return value, logRecordPos, err
+}
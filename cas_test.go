@@ -0,0 +1,149 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(10)
+	err = db.PutIfAbsent(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	err = db.PutIfAbsent(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Equal(t, ErrKeyAlreadyExists, err)
+	v1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v1)
+
+	// 删除之后可以再次写入
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.PutIfAbsent(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	// 并发写入只有一个成功，RandomValue 不是并发安全的，提前生成写入的数据
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var success int
+	values := make([][]byte, 20)
+	for i := range values {
+		values[i] = utils.RandomValue(10)
+	}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(value []byte) {
+			defer wg.Done()
+			if err := db.PutIfAbsent(utils.GetTestKey(2), value); err == nil {
+				mu.Lock()
+				success++
+				mu.Unlock()
+			}
+		}(values[i])
+	}
+	wg.Wait()
+	assert.Equal(t, 1, success)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("x"), []byte("b"))
+	assert.Equal(t, ErrValueNotMatch, err)
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
+
+func TestDB_CASWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 获取会过期的租约
+	lease := []byte("leader")
+	err = db.PutIfAbsentWithTTL(lease, []byte("node-1"), 200*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutIfAbsentWithTTL(lease, []byte("node-2"), 200*time.Millisecond)
+	assert.Equal(t, ErrKeyAlreadyExists, err)
+	expiresAt := db.index.Get(lease).ExpiresAt
+	assert.True(t, expiresAt > 0)
+
+	// 不带过期时间的CAS保留原有的过期时间
+	err = db.CompareAndSwap(lease, []byte("node-1"), []byte("node-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, expiresAt, db.index.Get(lease).ExpiresAt)
+
+	// 续约
+	time.Sleep(120 * time.Millisecond)
+	err = db.CompareAndSwapWithTTL(lease, []byte("node-1"), []byte("node-1"), 200*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, db.index.Get(lease).ExpiresAt > expiresAt)
+	time.Sleep(120 * time.Millisecond)
+	val, err := db.Get(lease)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("node-1"), val)
+
+	// 租约过期之后其他节点可以获取
+	time.Sleep(120 * time.Millisecond)
+	err = db.CompareAndSwapWithTTL(lease, []byte("node-1"), []byte("node-1"), 200*time.Millisecond)
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.PutIfAbsentWithTTL(lease, []byte("node-2"), 200*time.Millisecond)
+	assert.Nil(t, err)
+
+	// ttl <= 0 表示永不过期
+	err = db.CompareAndSwapWithTTL(lease, []byte("node-2"), []byte("node-2"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.index.Get(lease).ExpiresAt)
+}
+
+func TestDB_DeleteIfEqual(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.DeleteIfEqual(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.DeleteIfEqual(utils.GetTestKey(1), []byte("b"))
+	assert.Equal(t, ErrValueNotMatch, err)
+	err = db.DeleteIfEqual(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
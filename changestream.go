@@ -0,0 +1,282 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"context"
+)
+
+// 日志中的位置，由数据文件id和文件内的偏移量组成
+type LogPosition struct {
+	Fid    uint32
+	Offset int64
+}
+
+// 从日志中读取到的变更记录
+type ChangeRecord struct {
+	Type      WatchEventType
+	Key       []byte
+	Value     []byte
+	ExpiresAt int64
+	SeqNo     uint64      // 所属事务的序列号，非事务写入为0
+	Position  LogPosition // 记录在日志中的位置
+	Next      LogPosition // 处理完这条记录后恢复订阅的位置
+}
+
+// 按日志顺序读取变更记录的变更流
+type ChangeStream struct {
+	db         *DB
+	pos        LogPosition                // 下一条待读取记录的位置
+	txnRecords map[uint64][]*ChangeRecord // 暂存还没有读到完成标记的事务数据
+	ready      []*ChangeRecord            // 已经解析完成，等待返回的记录
+	done       chan struct{}
+	closed     bool
+}
+
+// 当前日志末尾的位置，从这里订阅只会读取到之后的写入
+func (db *DB) CurrentLogPosition() LogPosition {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.activeFile == nil {
+		return LogPosition{}
+	}
+	return LogPosition{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff}
+}
+
+// 从指定位置开始订阅变更，from 为零值时从最早的数据文件开始读取
+// 变更流跟随活跃文件的切换持续读取，事务中的数据在读到完成标记后才会返回，未提交的事务不会返回
+// 位置需要是之前 ChangeRecord.Next 或者 CurrentLogPosition 返回的值，
+// 位置所在的数据文件在重启时已经被merge替换则返回 ErrCursorCompacted，
+// 读取到的记录引用的blob文件已经被 CompactBlobs 删除时 Next 同样返回 ErrCursorCompacted
+// CompactBlobs 搬移blob记录时重新写入的记录不会返回
+func (db *DB) Subscribe(from LogPosition) (*ChangeStream, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if from == (LogPosition{}) {
+		from.Fid = db.firstFileId()
+	} else {
+		if from.Fid < db.nonMergeFileId {
+			return nil, ErrCursorCompacted
+		}
+		dataFile, limit, err := db.changeStreamFile(from.Fid)
+		if err != nil {
+			return nil, err
+		}
+		if dataFile == nil || from.Offset < 0 || from.Offset > limit {
+			return nil, ErrInvalidLogPosition
+		}
+	}
+
+	cs := &ChangeStream{
+		db:         db,
+		pos:        from,
+		txnRecords: make(map[uint64][]*ChangeRecord),
+		done:       make(chan struct{}),
+	}
+	db.changeStreams[cs] = struct{}{}
+	return cs, nil
+}
+
+// 读取下一条变更记录，没有新的记录时阻塞，直到有新的写入、ctx 结束或者变更流被关闭
+// 同一个变更流不能在多个goroutine中同时调用
+func (cs *ChangeStream) Next(ctx context.Context) (*ChangeRecord, error) {
+	for len(cs.ready) == 0 {
+		select {
+		case <-cs.done:
+			return nil, ErrChangeStreamClosed
+		default:
+		}
+
+		wait, err := cs.fill()
+		if err != nil {
+			return nil, err
+		}
+		if wait == nil {
+			continue
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-cs.done:
+			return nil, ErrChangeStreamClosed
+		}
+	}
+
+	record := cs.ready[0]
+	cs.ready[0] = nil
+	cs.ready = cs.ready[1:]
+	return record, nil
+}
+
+// 关闭变更流，数据库关闭时会自动关闭所有变更流
+func (cs *ChangeStream) Close() {
+	cs.db.mu.Lock()
+	defer cs.db.mu.Unlock()
+	cs.db.closeChangeStream(cs)
+}
+
+// 从当前位置读取记录，直到得到至少一条可以返回的记录或者读到日志末尾
+// 读到日志末尾时返回等待新写入的channel
+func (cs *ChangeStream) fill() (<-chan struct{}, error) {
+	db := cs.db
+
+	db.mu.Lock()
+	if db.activeFile == nil {
+		wait := db.logUpdatedCh()
+		db.mu.Unlock()
+		return wait, nil
+	}
+	dataFile, limit, err := db.changeStreamFile(cs.pos.Fid)
+	if err != nil {
+		db.mu.Unlock()
+		return nil, err
+	}
+	if dataFile == nil {
+		db.mu.Unlock()
+		return nil, ErrCursorCompacted
+	}
+	if cs.pos.Offset >= limit {
+		// 活跃文件已经读完，等待新的写入
+		if dataFile == db.activeFile {
+			wait := db.logUpdatedCh()
+			db.mu.Unlock()
+			return wait, nil
+		}
+		// 旧的数据文件已经读完，切换到下一个数据文件
+		cs.pos = LogPosition{Fid: db.nextFileId(cs.pos.Fid)}
+		db.mu.Unlock()
+		return nil, nil
+	}
+	db.mu.Unlock()
+
+	// limit 之前的记录都已经完整写入，可以不加锁读取
+	for cs.pos.Offset < limit && len(cs.ready) == 0 {
+		logRecord, size, err := db.readLogRecord(dataFile, cs.pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		// 搬移blob记录时写入的记录与之前的记录相同，跳过
+		if logRecord.Flags&data.LogRecordRelocated != 0 {
+			cs.pos.Offset += size
+			continue
+		}
+		// blob文件可能被 CompactBlobs 删除，需要持有锁读取
+		// 文件已经删除说明这条记录在blob文件回收之前写入，之前的日志已经无法完整读取
+		if logRecord.Flags&data.LogRecordBlob != 0 {
+			db.mu.RLock()
+			err = db.readBlobValue(logRecord)
+			db.mu.RUnlock()
+			if err == ErrDataFileNotFound {
+				return nil, ErrCursorCompacted
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		recordPos := cs.pos
+		cs.pos.Offset += size
+		cs.handleLogRecord(logRecord, recordPos)
+	}
+	return nil, nil
+}
+
+// 解析一条日志记录，事务数据暂存到读取到完成标记为止
+func (cs *ChangeStream) handleLogRecord(logRecord *data.LogRecord, recordPos LogPosition) {
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+
+	if logRecord.Type == data.LogRecordTxnFinished {
+		records := cs.txnRecords[seqNo]
+		delete(cs.txnRecords, seqNo)
+		if len(records) == 0 {
+			return
+		}
+		// 事务中间的记录从事务开头恢复，保证事务不会只被处理一部分
+		for _, record := range records[:len(records)-1] {
+			record.Next = records[0].Position
+		}
+		records[len(records)-1].Next = cs.pos
+		cs.ready = append(cs.ready, records...)
+		return
+	}
+
+	record := &ChangeRecord{
+		Type:      watchEventTypeOf(logRecord.Type),
+		Key:       realKey,
+		Value:     logRecord.Value,
+		ExpiresAt: logRecord.ExpiresAt,
+		SeqNo:     seqNo,
+		Position:  recordPos,
+	}
+	if seqNo == nonTransactionSeqNo {
+		record.Next = cs.pos
+		cs.ready = append(cs.ready, record)
+		return
+	}
+	cs.txnRecords[seqNo] = append(cs.txnRecords[seqNo], record)
+}
+
+// 获取变更流读取的数据文件和可以读取的上限，文件不存在时返回nil，调用方需要持有db.mu
+func (db *DB) changeStreamFile(fid uint32) (*data.DataFile, int64, error) {
+	if db.activeFile == nil {
+		return nil, 0, nil
+	}
+	if fid == db.activeFile.FileId {
+		return db.activeFile, db.activeFile.WriteOff, nil
+	}
+
+	dataFile := db.olderFiles[fid]
+	if dataFile == nil {
+		return nil, 0, nil
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	return dataFile, size, nil
+}
+
+// 最早的数据文件id，调用方需要持有db.mu
+func (db *DB) firstFileId() uint32 {
+	if db.activeFile == nil {
+		return 0
+	}
+	first := db.activeFile.FileId
+	for fid := range db.olderFiles {
+		if fid < first {
+			first = fid
+		}
+	}
+	return first
+}
+
+// fid 之后的下一个数据文件id，merge之后文件id可能不连续，调用方需要持有db.mu
+func (db *DB) nextFileId(fid uint32) uint32 {
+	next := db.activeFile.FileId
+	for id := range db.olderFiles {
+		if id > fid && id < next {
+			next = id
+		}
+	}
+	return next
+}
+
+// 获取有新日志写入时关闭的channel，调用方需要持有db.mu
+func (db *DB) logUpdatedCh() <-chan struct{} {
+	if db.logUpdated == nil {
+		db.logUpdated = make(chan struct{})
+	}
+	return db.logUpdated
+}
+
+// 关闭变更流，调用方需要持有db.mu
+func (db *DB) closeChangeStream(cs *ChangeStream) {
+	if cs.closed {
+		return
+	}
+	cs.closed = true
+	delete(db.changeStreams, cs)
+	close(cs.done)
+}
@@ -0,0 +1,214 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Subscribe(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-subscribe-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	cs, err := db.Subscribe(LogPosition{})
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("key-1"), []byte("a")))
+	assert.Nil(t, db.Delete([]byte("key-1")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("key-2"), []byte("b")))
+	assert.Nil(t, wb.Put([]byte("key-3"), []byte("c")))
+	assert.Nil(t, wb.Commit())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	record, err := cs.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, WatchEventPut, record.Type)
+	assert.Equal(t, []byte("key-1"), record.Key)
+	assert.Equal(t, []byte("a"), record.Value)
+	record, err = cs.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, WatchEventDelete, record.Type)
+
+	// 事务中的记录带有相同的序列号，中间的记录从事务开头恢复
+	txn1, err := cs.Next(ctx)
+	assert.Nil(t, err)
+	txn2, err := cs.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, txn1.SeqNo, txn2.SeqNo)
+	assert.NotEqual(t, nonTransactionSeqNo, txn1.SeqNo)
+	assert.Equal(t, txn1.Position, txn1.Next)
+	assert.Equal(t, db.CurrentLogPosition(), txn2.Next)
+
+	// 读到末尾后阻塞，直到有新的写入
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = db.Put([]byte("key-4"), []byte("d"))
+	}()
+	record, err = cs.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-4"), record.Key)
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer shortCancel()
+	_, err = cs.Next(shortCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	cs.Close()
+	_, err = cs.Next(ctx)
+	assert.Equal(t, ErrChangeStreamClosed, err)
+
+	_, err = db.Subscribe(LogPosition{Fid: 100})
+	assert.Equal(t, ErrInvalidLogPosition, err)
+}
+
+func TestDB_SubscribeFileRotation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-subscribe-2")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cs, err := db.Subscribe(LogPosition{})
+	assert.Nil(t, err)
+	var resume LogPosition
+	for i := 0; i < 500; i++ {
+		record, err := cs.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), record.Key)
+		resume = record.Next
+	}
+	cs.Close()
+
+	// 从上次处理到的位置恢复订阅
+	cs, err = db.Subscribe(resume)
+	assert.Nil(t, err)
+	for i := 500; i < 1000; i++ {
+		record, err := cs.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), record.Key)
+	}
+	cs.Close()
+}
+
+func TestDB_SubscribeCompacted(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-subscribe-3")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	oldPos := db.CurrentLogPosition()
+	assert.Nil(t, db.Merge())
+
+	// merge之后文件在重启前仍然可以读取
+	cs, err := db.Subscribe(oldPos)
+	assert.Nil(t, err)
+	cs.Close()
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	_, err = db2.Subscribe(oldPos)
+	assert.Equal(t, ErrCursorCompacted, err)
+
+	// 从头订阅读取到merge之后的数据
+	cs, err = db2.Subscribe(LogPosition{})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 50; i < 100; i++ {
+		record, err := cs.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), record.Key)
+	}
+
+	for i := 0; i < 100; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		if i < 50 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestDB_SubscribeCompactBlobs(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-subscribe-4")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.ValueThreshold = 256
+	opts.BlobMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 400; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	assert.True(t, len(db.olderBlobFiles) > 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cs, err := db.Subscribe(LogPosition{})
+	assert.Nil(t, err)
+	defer cs.Close()
+	for i := 0; i < 600; i++ {
+		record, err := cs.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i%400), record.Key)
+	}
+
+	// 搬移blob记录时写入的记录不会返回
+	assert.Nil(t, db.CompactBlobs())
+	_, err = os.Stat(data.GetBlobFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Put([]byte("after"), []byte("value")))
+	record, err := cs.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), record.Key)
+
+	// blob文件已经删除，之前的位置无法完整读取
+	cs2, err := db.Subscribe(LogPosition{})
+	assert.Nil(t, err)
+	defer cs2.Close()
+	_, err = cs2.Next(ctx)
+	assert.Equal(t, ErrCursorCompacted, err)
+}
@@ -0,0 +1,182 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// 在目录 dir 中创建数据库的一致性检查点，dir 可以直接使用 Open 打开
+// 切换活跃文件之后，不再修改的数据文件以及 hint-index 等文件通过硬链接放入 dir，无法创建硬链接时拷贝文件
+// 只有切换活跃文件时持有锁，创建链接和拷贝文件时不阻塞读写
+// b+树索引文件会随写入修改，持有锁时开启只读事务，释放锁之后从事务中拷贝
+func (db *DB) Checkpoint(dir string) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return ErrCheckpointDirNotEmpty
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	var indexTx *bbolt.Tx
+	files, err := db.rotateActiveFile(func(seqNo uint64) error {
+		var err error
+		indexTx, err = db.copyMutableFiles(dir, seqNo)
+		return err
+	})
+	defer db.unpinBlobFiles()
+	if err != nil {
+		return err
+	}
+	if err := copyIndexFile(indexTx, dir); err != nil {
+		return err
+	}
+
+	// 切换之后的旧数据文件和blob文件在重启之前不会再被修改
+	for _, fid := range files.fileIds {
+		if err := utils.LinkOrCopyFile(data.GetDataFileName(db.options.DirPath, fid),
+			data.GetDataFileName(dir, fid)); err != nil {
+			return err
+		}
+	}
+	for _, fid := range files.blobFileIds {
+		if err := utils.LinkOrCopyFile(data.GetBlobFileName(db.options.DirPath, fid),
+			data.GetBlobFileName(dir, fid)); err != nil {
+			return err
+		}
+	}
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		src := filepath.Join(db.options.DirPath, fileName)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := utils.LinkOrCopyFile(src, filepath.Join(dir, fileName)); err != nil {
+			return err
+		}
+	}
+
+	// 检查点使用单独的活跃文件，避免写入检查点时修改原数据库的文件
+	activeFile, err := data.OpenDataFile(dir, files.activeFileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	if err := activeFile.Close(); err != nil {
+		return err
+	}
+	if !files.hasBlobFile {
+		return nil
+	}
+	activeBlobFile, err := data.OpenBlobFile(dir, files.activeBlobFileId)
+	if err != nil {
+		return err
+	}
+	return activeBlobFile.Close()
+}
+
+// 切换活跃文件之后不再修改的文件
+type rotatedFiles struct {
+	fileIds          []uint32 // 旧数据文件id
+	activeFileId     uint32   // 新的活跃文件id
+	blobFileIds      []uint32 // 旧blob文件id
+	activeBlobFileId uint32   // 新的活跃blob文件id
+	hasBlobFile      bool     // 是否存在blob文件
+}
+
+// 持有锁切换活跃文件和活跃blob文件，返回切换之后不再修改的文件
+// underLock 在持有锁时执行，参数为当前的事务序列号，用于保存会随写入修改的文件
+// 拷贝完成之前 CompactBlobs 不会删除blob文件，调用方需要在拷贝完成之后调用 unpinBlobFiles
+func (db *DB) rotateActiveFile(underLock func(seqNo uint64) error) (rotatedFiles, error) {
+	var files rotatedFiles
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.blobFilePins++
+
+	if db.activeFile == nil {
+		return files, nil
+	}
+
+	// 活跃文件为空时不需要切换，指针记录引用的blob文件先切换
+	if db.activeBlobFile != nil && db.activeBlobFile.WriteOff > 0 {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return files, err
+		}
+		db.olderBlobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		if err := db.setActiveBlobFile(); err != nil {
+			return files, err
+		}
+	}
+	if db.activeFile.WriteOff > 0 {
+		if err := db.sealActiveFile(); err != nil {
+			return files, err
+		}
+	}
+
+	if err := underLock(db.seqNo); err != nil {
+		return files, err
+	}
+
+	files.fileIds = sortedFileIds(db.olderFiles)
+	files.activeFileId = db.activeFile.FileId
+	if db.activeBlobFile != nil {
+		files.blobFileIds = sortedFileIds(db.olderBlobFiles)
+		files.activeBlobFileId = db.activeBlobFile.FileId
+		files.hasBlobFile = true
+	}
+	return files, nil
+}
+
+// 允许 CompactBlobs 删除 rotateActiveFile 返回的blob文件
+func (db *DB) unpinBlobFiles() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.blobFilePins--
+}
+
+// 按从小到大的顺序返回文件id
+func sortedFileIds(files map[uint32]*data.DataFile) []uint32 {
+	fileIds := make([]uint32, 0, len(files))
+	for fid := range files {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds
+}
+
+// b+树索引时开启只读事务，在持有锁时调用，释放锁之后从事务中拷贝一致的索引文件
+// 其他索引在内存中，返回 nil
+func (db *DB) beginIndexCopy() (*bbolt.Tx, error) {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok {
+		return nil, nil
+	}
+	return bpt.BeginRead()
+}
+
+// 在持有锁时调用，在目录 dir 中写入事务序列号文件，b+树索引时返回开启的只读事务
+// 调用方在释放锁之后使用 copyIndexFile 拷贝索引文件
+func (db *DB) copyMutableFiles(dir string, seqNo uint64) (*bbolt.Tx, error) {
+	if err := db.writeSeqNoFile(dir, seqNo); err != nil {
+		return nil, err
+	}
+	return db.beginIndexCopy()
+}
+
+// 从只读事务中拷贝b+树索引文件到目录 dir 并结束事务，tx 为 nil 时不需要拷贝
+func copyIndexFile(tx *bbolt.Tx, dir string) error {
+	if tx == nil {
+		return nil
+	}
+	defer tx.Rollback()
+	return tx.CopyFile(filepath.Join(dir, index.BPTreeIndexFileName), 0644)
+}
@@ -0,0 +1,164 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+
+	checkpointDir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-dir")
+	defer os.RemoveAll(checkpointDir)
+	assert.Nil(t, db.Checkpoint(checkpointDir))
+	assert.Equal(t, ErrCheckpointDirNotEmpty, db.Checkpoint(checkpointDir))
+
+	// 检查点之后的写入不影响检查点
+	for i := 500; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(2)))
+
+	cpOpts := opts
+	cpOpts.DirPath = checkpointDir
+	cp, err := Open(cpOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 499, len(cp.ListKeys()))
+	_, err = cp.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := cp.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	_, err = cp.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = cp.Get(utils.GetTestKey(500))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 写入检查点不影响原数据库
+	assert.Nil(t, cp.Put(utils.GetTestKey(3), []byte("checkpoint")))
+	assert.Nil(t, cp.Close())
+
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("checkpoint"), val)
+	assert.Equal(t, 598, len(db.ListKeys()))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 598, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("checkpoint"), val)
+}
+
+func TestDB_Checkpoint_AfterMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+
+	// 重启之后merge的结果生效，数据目录中包含 hint-index
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("after merge")))
+
+	checkpointDir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-dir")
+	defer os.RemoveAll(checkpointDir)
+	assert.Nil(t, db.Checkpoint(checkpointDir))
+	_, err = os.Stat(filepath.Join(checkpointDir, "hint-index"))
+	assert.Nil(t, err)
+
+	cpOpts := opts
+	cpOpts.DirPath = checkpointDir
+	cp, err := Open(cpOpts)
+	assert.Nil(t, err)
+	defer cp.Close()
+	assert.Equal(t, 501, len(cp.ListKeys()))
+	val, err := cp.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after merge"), val)
+}
+
+func TestDB_Checkpoint_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// 索引文件在释放锁之后拷贝，期间的写入不能出现在检查点的索引中
+	values := make([][]byte, 100)
+	for i := range values {
+		values[i] = utils.RandomValue(128)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i, value := range values {
+			assert.Nil(t, db.Put(utils.GetTestKey(1000+i), value))
+		}
+	}()
+	checkpointDir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-dir")
+	defer os.RemoveAll(checkpointDir)
+	assert.Nil(t, db.Checkpoint(checkpointDir))
+	<-done
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+
+	cpOpts := opts
+	cpOpts.DirPath = checkpointDir
+	cp, err := Open(cpOpts)
+	assert.Nil(t, err)
+	defer cp.Close()
+	keys := cp.ListKeys()
+	assert.True(t, len(keys) >= 100)
+	for _, key := range keys {
+		_, err := cp.Get(key)
+		assert.Nil(t, err)
+	}
+	_, err = cp.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 检查点包含事务序列号文件，可以使用批量写入
+	wb := cp.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(100), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+}
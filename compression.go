@@ -0,0 +1,178 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 压缩算法，实现该接口并通过 RegisterCompressor 注册之后可以使用外部的压缩算法
+type Compressor interface {
+	// 压缩算法的id，写入每条压缩的记录中，读取时根据id选择解压算法，不能为0
+	ID() byte
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// 内置压缩算法的id
+const (
+	flateCompressorID byte = iota + 1
+	gzipCompressorID
+)
+
+var (
+	FlateCompression Compressor = NewFlateCompressor(flate.DefaultCompression)
+	GzipCompression  Compressor = NewGzipCompressor(gzip.DefaultCompression)
+)
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[byte]Compressor{
+		flateCompressorID: FlateCompression,
+		gzipCompressorID:  GzipCompression,
+	}
+)
+
+// 注册压缩算法，之后打开的数据库都可以读取使用该算法压缩的记录
+// 更换压缩算法之后，旧的算法仍然需要注册，直到merge将旧数据重新压缩
+func RegisterCompressor(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	if c.ID() == 0 {
+		panic("bitcask: compressor id must not be 0")
+	}
+	if _, ok := compressors[c.ID()]; ok {
+		panic(fmt.Sprintf("bitcask: compressor %d is already registered", c.ID()))
+	}
+	compressors[c.ID()] = c
+}
+
+func getCompressor(id byte) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return compressors[id]
+}
+
+// 标准库 compress/flate 压缩
+type flateCompressor struct {
+	level int
+}
+
+func NewFlateCompressor(level int) Compressor {
+	return &flateCompressor{level: level}
+}
+
+func (fc *flateCompressor) ID() byte {
+	return flateCompressorID
+}
+
+func (fc *flateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, fc.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (fc *flateCompressor) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// 标准库 compress/gzip 压缩
+type gzipCompressor struct {
+	level int
+}
+
+func NewGzipCompressor(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+func (gc *gzipCompressor) ID() byte {
+	return gzipCompressorID
+}
+
+func (gc *gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gc.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gc *gzipCompressor) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// 按照配置压缩写入的value，压缩之后的value为 压缩算法id + 压缩数据
+// 小于 CompressionThreshold 或者压缩之后没有变小的value不压缩，返回原记录，指针记录的value由blob记录压缩
+func (db *DB) compressLogRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	c := db.options.Compression
+	if c == nil || len(logRecord.Value) < db.options.CompressionThreshold ||
+		logRecord.Flags&(data.LogRecordCompressed|data.LogRecordBlob) != 0 ||
+		(logRecord.Type != data.LogRecordNormal && logRecord.Type != data.LogRecordMerge) {
+		return logRecord, nil
+	}
+
+	compressed, err := c.Compress(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed)+1 >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+
+	record := *logRecord
+	record.Value = append([]byte{c.ID()}, compressed...)
+	record.Flags |= data.LogRecordCompressed
+	return &record, nil
+}
+
+// 解压读取的value
+func (db *DB) decompressLogRecord(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.LogRecordCompressed == 0 {
+		return nil
+	}
+	if len(logRecord.Value) == 0 {
+		return ErrDataDirectoryCorrupted
+	}
+
+	id := logRecord.Value[0]
+	c := db.options.Compression
+	if c == nil || c.ID() != id {
+		if c = getCompressor(id); c == nil {
+			return fmt.Errorf("%w: %d", ErrUnknownCompressor, id)
+		}
+	}
+	value, err := c.Decompress(logRecord.Value[1:])
+	if err != nil {
+		return err
+	}
+	logRecord.Value = value
+	logRecord.Flags &^= data.LogRecordCompressed
+	return nil
+}
@@ -0,0 +1,194 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 与flate相同，但是使用不同id的外部压缩算法
+type testCompressor struct {
+	id byte
+}
+
+func (tc *testCompressor) ID() byte {
+	return tc.id
+}
+
+func (tc *testCompressor) Compress(src []byte) ([]byte, error) {
+	return FlateCompression.Compress(src)
+}
+
+func (tc *testCompressor) Decompress(src []byte) ([]byte, error) {
+	return FlateCompression.Decompress(src)
+}
+
+// 统计数据文件中各压缩算法压缩的记录数量，key 0 表示未压缩
+func countCompressedRecords(t *testing.T, db *DB) map[byte]int {
+	counts := make(map[byte]int)
+	fileIds, err := listDataFileIds(db.options.DirPath)
+	assert.Nil(t, err)
+	for _, fid := range fileIds {
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), fio.StandardFIO)
+		assert.Nil(t, err)
+		var offset int64
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			offset += size
+			if logRecord.Type != data.LogRecordNormal {
+				continue
+			}
+			if logRecord.Flags&data.LogRecordCompressed != 0 {
+				counts[logRecord.Value[0]]++
+			} else {
+				counts[0]++
+			}
+		}
+		assert.Nil(t, dataFile.Close())
+	}
+	return counts
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.Compression = FlateCompression
+	opts.CompressionThreshold = 64
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	large := bytes.Repeat([]byte("bitcask-go "), 100)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), large))
+	}
+	// 小于阈值以及无法压缩的value不压缩
+	small := []byte("small value")
+	random := utils.RandomValue(1024)
+	assert.Nil(t, db.Put(utils.GetTestKey(100), small))
+	assert.Nil(t, db.Put(utils.GetTestKey(101), random))
+
+	counts := countCompressedRecords(t, db)
+	assert.Equal(t, 100, counts[flateCompressorID])
+	assert.Equal(t, 2, counts[0])
+	assert.True(t, db.activeFile.WriteOff < int64(len(large)*10))
+
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	val, err = db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+
+	// 关闭压缩之后，压缩和未压缩的记录可以同时存在
+	assert.Nil(t, db.Close())
+	opts.Compression = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(102), large))
+	for _, i := range []int{0, 99, 102} {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, large, val)
+	}
+	val, err = db.Get(utils.GetTestKey(101))
+	assert.Nil(t, err)
+	assert.Equal(t, random, val)
+
+	// 迭代器和批量写入
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(103), large))
+	assert.Nil(t, wb.Commit())
+	var n int
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		n++
+		return true
+	}))
+	assert.Equal(t, 104, n)
+}
+
+func TestDB_Compression_MergeRecompress(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-merge")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Compression = GzipCompression
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	large := bytes.Repeat([]byte("bitcask-go "), 100)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), large))
+	}
+	assert.Nil(t, db.Close())
+
+	// 更换压缩算法之后由merge重新压缩旧数据
+	opts.Compression = NewFlateCompressor(9)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, countCompressedRecords(t, db)[gzipCompressorID])
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	counts := countCompressedRecords(t, db)
+	assert.Equal(t, 0, counts[gzipCompressorID])
+	assert.Equal(t, 100, counts[flateCompressorID])
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, large, val)
+	}
+}
+
+func TestDB_Compression_Custom(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-custom")
+	opts.DirPath = dir
+	opts.Compression = &testCompressor{id: 200}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	large := bytes.Repeat([]byte("bitcask-go "), 100)
+	assert.Nil(t, db.Put([]byte("key"), large))
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	assert.Equal(t, 1, countCompressedRecords(t, db)[200])
+
+	// 未注册的压缩算法无法读取
+	assert.Nil(t, db.Close())
+	opts.Compression = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("key"))
+	assert.True(t, errors.Is(err, ErrUnknownCompressor))
+
+	// 注册之后可以读取
+	RegisterCompressor(&testCompressor{id: 200})
+	val, err = db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+
+	assert.Panics(t, func() {
+		RegisterCompressor(&testCompressor{id: 200})
+	})
+	assert.Panics(t, func() {
+		RegisterCompressor(&testCompressor{id: 0})
+	})
+}
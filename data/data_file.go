@@ -11,9 +11,15 @@ import (
 
 const (
 	DataFileNameSuffix    = ".data"
+	BlobFileNameSuffix    = ".blob"
+	HintFileNameSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	BloomFilterFileName   = "bloom-filter"
+
+	IndexSnapshotFileName     = "index-snapshot"
+	IndexSnapshotTempFileName = "index-snapshot.tmp"
 )
 
 // 数据文件
@@ -33,6 +39,12 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDataFile(fileName, fileId, ioType)
 }
 
+// 打开保存大value的blob文件
+func OpenBlobFile(dirPath string, fileId uint32) (*DataFile, error) {
+	fileName := GetBlobFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, fio.StandardFIO)
+}
+
 // 打开hint索引文件
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
@@ -45,6 +57,11 @@ func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// 打开索引快照文件
+func OpenIndexSnapshotFile(dirPath, fileName string) (*DataFile, error) {
+	return newDataFile(filepath.Join(dirPath, fileName), 0, fio.StandardFIO)
+}
+
 // 打开事务序列号文件
 func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
@@ -55,6 +72,20 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+// 打开数据文件对应的hint文件
+func OpenDataFileHint(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetDataFileHintName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
+}
+
+func GetDataFileHintName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	ioManager, err := fio.NewIOManager(fileName, ioType)
 	if err != nil {
@@ -103,8 +134,15 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
 
+	// 记录超出了文件末尾，说明记录没有写完整
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
+
 	logReord := &LogRecord{
-		Type: header.recordType,
+		Type:      header.recordType,
+		Flags:     header.flags,
+		ExpiresAt: header.expiresAt,
 	}
 
 	// 读取kv
@@ -127,6 +165,15 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	return logReord, recordSize, nil
 }
 
+// 截断数据文件，丢弃offset之后的数据
+func (df *DataFile) Truncate(offset int64) error {
+	if err := df.IoManager.Truncate(offset); err != nil {
+		return err
+	}
+	df.WriteOff = offset
+	return df.IoManager.Sync()
+}
+
 // 持久化到磁盘
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
@@ -11,31 +11,64 @@ const (
 	LogRecordNormal      LogRecordType = iota // 写入
 	LogRecordDeleted                          // 删除
 	LogRecordTxnFinished                      // 结束标记
+	LogRecordMerge                            // 合并操作数
 )
 
-// crc type keysize valuesize : 4 + 1 + 5 + 5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5 // 单个日志记录的头部大小
+// 日志记录的标记，多个标记可以同时存在
+type LogRecordFlags = byte
+
+const (
+	LogRecordCompressed LogRecordFlags = 1 << iota // value 已压缩
+	LogRecordEncrypted                             // key 和 value 已加密
+	LogRecordBlob                                  // value 保存在blob文件中，记录中只保存blob记录的位置
+	LogRecordRelocated                             // CompactBlobs 搬移blob记录时重新写入，值没有变化
+)
+
+// 记录类型的最高位表示类型之后有一个标记字节，次高位表示头部带有过期时间
+// 没有标记也没有过期时间的记录与旧格式相同
+const (
+	logRecordFlagsMarker     byte = 0x80
+	logRecordExpiresAtMarker byte = 0x40
+)
+
+// crc type flags keysize valuesize expiresAt : 4 + 1 + 1 + 5 + 5 + 10
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 6 // 单个日志记录的头部大小
 
 // 写入到数据文件的记录，添加写
 type LogRecord struct {
-	Key   []byte        // 键
-	Value []byte        // 值
-	Type  LogRecordType // 类型
+	Key       []byte         // 键
+	Value     []byte         // 值
+	Type      LogRecordType  // 类型
+	Flags     LogRecordFlags // 标记
+	ExpiresAt int64          // 过期时间，UnixNano，0 表示永不过期
+}
+
+// 判断记录在 now 时刻是否已经过期
+func (lr *LogRecord) IsExpired(now int64) bool {
+	return lr.ExpiresAt > 0 && now >= lr.ExpiresAt
 }
 
 // 日志记录头部
 type LogRecordHeader struct {
-	crc        uint32        // 校验和
-	recordType LogRecordType // 记录类型
-	keySize    uint32        // 键大小
-	valueSize  uint32        // 值大小
+	crc        uint32         // 校验和
+	recordType LogRecordType  // 记录类型
+	flags      LogRecordFlags // 标记
+	keySize    uint32         // 键大小
+	valueSize  uint32         // 值大小
+	expiresAt  int64          // 过期时间
 }
 
 // 数据内存索引，数据在磁盘上的位置
 type LogRecordPos struct {
-	Fid    uint32 // 文件ID
-	Offset int64  // 偏移量
-	Size   uint32 // 大小
+	Fid       uint32 // 文件ID
+	Offset    int64  // 偏移量
+	Size      uint32 // 大小
+	ExpiresAt int64  // 过期时间，0 表示永不过期
+}
+
+// 判断该位置上的数据在 now 时刻是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.ExpiresAt > 0 && now >= pos.ExpiresAt
 }
 
 // 暂存的事务数据
@@ -44,20 +77,29 @@ type TransactionRecord struct {
 	Pos    *LogRecordPos
 }
 
-// 编码日志记录
-// +---------------+---------------------+------------------+---------------------+------+--------+
-// | crc (4 bytes) | recordType (1 byte) | keySize (5 bytes)| valueSize (5 bytes) | key  | value  |
-// +---------------+---------------------+------------------+---------------------+------+--------+
+// 编码日志记录，flags 只在记录带有标记时写入，expiresAt 只在记录会过期时写入
+// +---------------+---------------------+------------------+------------------+---------------------+------------------------+------+--------+
+// | crc (4 bytes) | recordType (1 byte) | flags (0/1 byte) | keySize (5 bytes)| valueSize (5 bytes) | expiresAt (0/10 bytes) | key  | value  |
+// +---------------+---------------------+------------------+------------------+---------------------+------------------------+------+--------+
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 编码日志记录头部
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	header[4] = logRecord.Type
 	var index = 5
+	if logRecord.Flags != 0 {
+		header[4] |= logRecordFlagsMarker
+		header[5] = logRecord.Flags
+		index++
+	}
 
 	// 存储变长kv
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	if logRecord.ExpiresAt != 0 {
+		header[4] |= logRecordExpiresAtMarker
+		index += binary.PutVarint(header[index:], logRecord.ExpiresAt)
+	}
 
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
@@ -74,11 +116,12 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 
 // 编码日志位置信息
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	index += binary.PutVarint(buf[index:], pos.ExpiresAt)
 	return buf[:index]
 }
 
@@ -88,11 +131,18 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
+	index += n
+	// 兼容没有过期时间的旧格式
+	var expiresAt int64
+	if index < len(buf) {
+		expiresAt, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
-		Fid:    uint32(fileId),
-		Offset: offset,
-		Size:   uint32(size),
+		Fid:       uint32(fileId),
+		Offset:    offset,
+		Size:      uint32(size),
+		ExpiresAt: expiresAt,
 	}
 }
 
@@ -108,6 +158,16 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	}
 
 	var index = 5
+	hasExpiresAt := header.recordType&logRecordExpiresAtMarker != 0
+	header.recordType &^= logRecordExpiresAtMarker
+	if header.recordType&logRecordFlagsMarker != 0 {
+		if len(buf) <= 5 {
+			return nil, 0
+		}
+		header.recordType &^= logRecordFlagsMarker
+		header.flags = buf[5]
+		index++
+	}
 	// 读取变长kv size
 	keySize, n := binary.Varint(buf[index:])
 	header.keySize = uint32(keySize)
@@ -115,6 +175,11 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	valueSize, n := binary.Varint(buf[index:])
 	header.valueSize = uint32(valueSize)
 	index += n
+	if hasExpiresAt {
+		expiresAt, n := binary.Varint(buf[index:])
+		header.expiresAt = expiresAt
+		index += n
+	}
 
 	return header, int64(index)
 }
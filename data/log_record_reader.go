@@ -0,0 +1,105 @@
+package data
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+)
+
+// 顺序读取时缓冲区的大小
+const logRecordReaderBufferSize = 256 * 1024
+
+// 从offset处开始顺序读取数据文件中的日志记录
+// 通过缓冲区批量读取文件，不需要每条记录分别读取header和kv
+type LogRecordReader struct {
+	reader *bufio.Reader
+	offset int64
+	size   int64
+}
+
+// 读取数据文件时 IOManager 的 Read 与 io.ReaderAt 相同
+type ioManagerReaderAt struct {
+	df *DataFile
+}
+
+func (r ioManagerReaderAt) ReadAt(b []byte, offset int64) (int, error) {
+	return r.df.IoManager.Read(b, offset)
+}
+
+// 创建顺序读取器，只读取创建时文件中已有的数据
+func (df *DataFile) NewLogRecordReader(offset int64) (*LogRecordReader, error) {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if offset > size {
+		offset = size
+	}
+	section := io.NewSectionReader(ioManagerReaderAt{df: df}, offset, size-offset)
+	return &LogRecordReader{
+		reader: bufio.NewReaderSize(section, logRecordReaderBufferSize),
+		offset: offset,
+		size:   size,
+	}, nil
+}
+
+// 读取下一条日志记录，返回记录和记录大小，与 ReadLogRecord 相同，读到文件末尾或者不完整的记录时返回 io.EOF
+func (r *LogRecordReader) Next() (*LogRecord, int64, error) {
+	headerBuf, err := r.reader.Peek(maxLogRecordHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+
+	// 读到了文件末尾
+	if header == nil {
+		return nil, 0, io.EOF
+	}
+
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, 0, io.EOF
+	}
+
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	var recordSize = headerSize + keySize + valueSize
+
+	// 记录超出了文件末尾，说明记录没有写完整
+	if r.offset+recordSize > r.size {
+		return nil, 0, io.EOF
+	}
+
+	logRecord := &LogRecord{
+		Type:      header.recordType,
+		Flags:     header.flags,
+		ExpiresAt: header.expiresAt,
+	}
+
+	// 缓冲区中的header在读取kv之后会被覆盖，先复制用于校验
+	crcHeader := append([]byte(nil), headerBuf[crc32.Size:headerSize]...)
+	if _, err := r.reader.Discard(int(headerSize)); err != nil {
+		return nil, 0, err
+	}
+
+	// 读取kv
+	if keySize > 0 || valueSize > 0 {
+		kvBuf := make([]byte, keySize+valueSize)
+		if _, err := io.ReadFull(r.reader, kvBuf); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, 0, io.EOF
+			}
+			return nil, 0, err
+		}
+		logRecord.Key = kvBuf[:keySize]
+		logRecord.Value = kvBuf[keySize:]
+	}
+
+	// 校验
+	crc := getLogRecordCRC(logRecord, crcHeader)
+	if crc != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+
+	r.offset += recordSize
+	return logRecord, recordSize, nil
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRecordReader_Next(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-log-record-reader")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// 包含超过缓冲区大小的记录
+	records := []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask-go")},
+		{Key: []byte("large"), Value: bytes.Repeat([]byte("v"), 2*logRecordReaderBufferSize)},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+		{Key: []byte("ttl"), Value: []byte("value"), ExpiresAt: 100},
+	}
+	for _, record := range records {
+		encRecord, _ := EncodeLogRecord(record)
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	// 末尾不完整的记录
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("tail"), Value: []byte("incomplete")})
+	assert.Nil(t, dataFile.Write(encRecord[:len(encRecord)-3]))
+
+	reader, err := dataFile.NewLogRecordReader(0)
+	assert.Nil(t, err)
+	var offset int64
+	for _, record := range records {
+		logRecord, size, err := reader.Next()
+		assert.Nil(t, err)
+		expected, expectedSize, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, logRecord)
+		assert.Equal(t, expectedSize, size)
+		assert.Equal(t, record.Key, logRecord.Key)
+		offset += size
+	}
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// 从记录中间开始读取时返回错误
+	reader, err = dataFile.NewLogRecordReader(offset - 10)
+	assert.Nil(t, err)
+	_, _, err = reader.Next()
+	assert.NotNil(t, err)
+}
+
+func TestDataFile_FindLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-find-log-record")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// 超过一个窗口的损坏数据之后是一条正常记录和一条超过窗口大小的记录
+	garbage := bytes.Repeat([]byte{0xff, 0x01, 0x7f}, resyncWindowSize/2)
+	assert.Nil(t, dataFile.Write(garbage))
+	encRecord, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	large, _ := EncodeLogRecord(&LogRecord{Key: []byte("large"), Value: bytes.Repeat([]byte("v"), resyncWindowSize)})
+	assert.Nil(t, dataFile.Write(large))
+
+	offset, err := dataFile.FindLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(garbage)), offset)
+	offset, err = dataFile.FindLogRecord(offset + 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(garbage))+size, offset)
+
+	// 最后一条记录不完整时找不到有效记录
+	assert.Nil(t, dataFile.Truncate(dataFile.WriteOff-1))
+	_, err = dataFile.FindLogRecord(offset)
+	assert.Equal(t, io.EOF, err)
+}
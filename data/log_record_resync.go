@@ -0,0 +1,73 @@
+package data
+
+import (
+	"hash/crc32"
+	"io"
+)
+
+// 查找有效记录时每次从文件中读取的数据大小
+const resyncWindowSize = 1024 * 1024
+
+// 从offset开始查找下一条校验通过的完整记录，返回记录的位置，没有找到时返回 io.EOF
+// 按窗口批量读取文件，在内存中逐个位置解码头部并校验，不需要每个位置分别读取文件
+func (df *DataFile) FindLogRecord(offset int64) (int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	// 相邻的窗口重叠一个头部的大小，窗口末尾的位置也可以完整解码头部
+	buf := make([]byte, resyncWindowSize+maxLogRecordHeaderSize)
+	for start := offset; start < fileSize; start += resyncWindowSize {
+		n := int64(len(buf))
+		if start+n > fileSize {
+			n = fileSize - start
+		}
+		window := buf[:n]
+		if _, err := df.IoManager.Read(window, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		limit := n
+		if limit > resyncWindowSize {
+			limit = resyncWindowSize
+		}
+		for i := int64(0); i < limit; i++ {
+			ok, err := df.isLogRecordAt(window[i:], start+i, fileSize)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return start + i, nil
+			}
+		}
+	}
+	return 0, io.EOF
+}
+
+// 判断文件中pos处是否是一条完整并且校验通过的记录，buf 为从pos开始读取的数据
+func (df *DataFile) isLogRecordAt(buf []byte, pos int64, fileSize int64) (bool, error) {
+	header, headerSize := decodeLogRecordHeader(buf)
+	if header == nil || header.recordType > LogRecordMerge || header.keySize == 0 {
+		return false, nil
+	}
+
+	recordSize := headerSize + int64(header.keySize) + int64(header.valueSize)
+	if pos+recordSize > fileSize {
+		return false, nil
+	}
+
+	// 记录完整地在窗口中时直接校验，否则从文件中读取
+	if recordSize <= int64(len(buf)) {
+		return crc32.ChecksumIEEE(buf[crc32.Size:recordSize]) == header.crc, nil
+	}
+	_, _, err := df.ReadLogRecord(pos)
+	switch err {
+	case nil:
+		return true, nil
+	case ErrInvalidCRC, io.EOF:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -52,3 +52,59 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc1 := getLogRecordCRC(rec1, headerBuf1[crc32.Size:])
 	assert.Equal(t, crc1, uint32(2452451443))
 }
+
+func TestLogRecordPos_ExpiresAt(t *testing.T) {
+	pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, ExpiresAt: 1700000000000000000}
+	buf := EncodeLogRecordPos(pos)
+	assert.Equal(t, pos, DecodeLogRecordPos(buf))
+
+	assert.False(t, pos.IsExpired(pos.ExpiresAt-1))
+	assert.True(t, pos.IsExpired(pos.ExpiresAt))
+
+	noExpire := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	assert.False(t, noExpire.IsExpired(pos.ExpiresAt))
+}
+
+func TestEncodeLogRecord_Flags(t *testing.T) {
+	rec := &LogRecord{
+		Key:   []byte("key"),
+		Value: []byte("value"),
+		Type:  LogRecordMerge,
+	}
+	_, plainSize := EncodeLogRecord(rec)
+
+	// 带有标记的记录多一个标记字节
+	rec.Flags = LogRecordCompressed
+	buf, size := EncodeLogRecord(rec)
+	assert.Equal(t, plainSize+1, size)
+
+	header, headerSize := decodeLogRecordHeader(buf)
+	assert.Equal(t, LogRecordMerge, header.recordType)
+	assert.Equal(t, LogRecordCompressed, header.flags)
+	assert.Equal(t, uint32(3), header.keySize)
+	assert.Equal(t, uint32(5), header.valueSize)
+	assert.Equal(t, size, headerSize+8)
+	assert.Equal(t, header.crc, getLogRecordCRC(rec, buf[crc32.Size:headerSize]))
+}
+
+func TestEncodeLogRecord_ExpiresAt(t *testing.T) {
+	rec := &LogRecord{
+		Key:   []byte("key1"),
+		Value: []byte("value1"),
+		Type:  LogRecordNormal,
+	}
+	// 不会过期的记录与旧格式相同
+	buf, size := EncodeLogRecord(rec)
+	assert.Equal(t, int64(7+10), size)
+	assert.Equal(t, []byte{0, 8, 12}, buf[4:7])
+
+	rec.ExpiresAt = 1700000000000000000
+	rec.Flags = LogRecordCompressed
+	buf, size = EncodeLogRecord(rec)
+	header, headerSize := decodeLogRecordHeader(buf)
+	assert.Equal(t, LogRecordNormal, header.recordType)
+	assert.Equal(t, LogRecordCompressed, header.flags)
+	assert.Equal(t, rec.ExpiresAt, header.expiresAt)
+	assert.Equal(t, size, headerSize+10)
+	assert.Equal(t, header.crc, getLogRecordCRC(rec, buf[crc32.Size:headerSize]))
+}
@@ -0,0 +1,281 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+var errInvalidDataFileHint = errors.New("invalid data file hint")
+
+// 数据文件中一条记录在回放时需要的信息，不包含value
+// 指针记录的 Value 为引用的blob记录位置，其他记录的 Value 为空
+type hintEntry struct {
+	record *data.LogRecord
+	pos    *data.LogRecordPos
+}
+
+func newHintEntry(key []byte, typ data.LogRecordType, pos, blobPos *data.LogRecordPos) *hintEntry {
+	record := &data.LogRecord{Key: key, Type: typ}
+	if blobPos != nil {
+		record.Flags = data.LogRecordBlob
+		record.Value = data.EncodeLogRecordPos(blobPos)
+	}
+	return &hintEntry{record: record, pos: pos}
+}
+
+// 将活跃文件转为旧的数据文件并打开新的活跃文件，同时为旧的数据文件生成hint文件
+// hint文件只用于加速启动，写入失败时不影响写入，下次启动时根据数据文件重新生成
+func (db *DB) sealActiveFile() error {
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+
+	if db.hintsEnabled() && !db.activeHintsIncomplete {
+		if err := db.writeDataFileHint(db.activeFile.FileId, db.activeFile.WriteOff, db.activeHints); err != nil {
+			log.Printf("bitcask: failed to write the hint file of data file %d, %v", db.activeFile.FileId, err)
+		}
+	}
+	db.activeHints = nil
+	db.activeHintsIncomplete = false
+
+	return db.setActiveDataFile()
+}
+
+// 只读模式不写入文件，b+树索引不从数据文件加载，都不需要hint文件
+func (db *DB) hintsEnabled() bool {
+	return !db.options.ReadOnly && db.options.IndexType != BPlusTree
+}
+
+// 记录活跃文件中追加的记录
+func (db *DB) trackHintEntry(entry *hintEntry) {
+	if !db.hintsEnabled() {
+		return
+	}
+	db.activeHints = append(db.activeHints, entry)
+}
+
+// 去掉被同一个文件中之后的非事务记录覆盖的非事务记录，返回保留的记录和去掉的记录大小
+// 被覆盖的记录在回放时同样会被丢弃，事务记录在读到完成标记时才生效，全部保留
+func pruneHintEntries(entries []*hintEntry) ([]*hintEntry, int64) {
+	overridden := make(map[string]struct{})
+	keep := make([]bool, len(entries))
+	var discardSize int64
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		realKey, seqNo := parseLogRecordKey(entry.record.Key)
+		if seqNo != nonTransactionSeqNo {
+			keep[i] = true
+			continue
+		}
+		if _, ok := overridden[string(realKey)]; ok {
+			discardSize += int64(entry.pos.Size)
+			continue
+		}
+		keep[i] = true
+		if entry.record.Type == data.LogRecordNormal || entry.record.Type == data.LogRecordDeleted {
+			overridden[string(realKey)] = struct{}{}
+		}
+	}
+
+	pruned := make([]*hintEntry, 0, len(entries))
+	for i, entry := range entries {
+		if keep[i] {
+			pruned = append(pruned, entry)
+		}
+	}
+	return pruned, discardSize
+}
+
+// 写入数据文件的hint文件，每条记录为 key + 记录类型 + 位置，指针记录同时保存blob记录位置
+// 最后一条记录的key为空，value为 数据文件大小 + 去掉的记录大小 + 记录数量，用于校验hint文件是否完整
+func (db *DB) writeDataFileHint(fileId uint32, fileSize int64, entries []*hintEntry) error {
+	entries, discardSize := pruneHintEntries(entries)
+
+	var buf []byte
+	for _, entry := range entries {
+		hintRecord := &data.LogRecord{
+			Key:   entry.record.Key,
+			Value: data.EncodeLogRecordPos(entry.pos),
+			Type:  entry.record.Type,
+		}
+		if entry.record.Flags&data.LogRecordBlob != 0 {
+			hintRecord.Value = encodeBlobHint(entry.pos, data.DecodeLogRecordPos(entry.record.Value))
+			hintRecord.Flags = data.LogRecordBlob
+		}
+		encRecord, err := db.encodeHintRecord(hintRecord)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, encRecord...)
+	}
+
+	end := make([]byte, binary.MaxVarintLen64*3)
+	n := binary.PutVarint(end, fileSize)
+	n += binary.PutVarint(end[n:], discardSize)
+	n += binary.PutUvarint(end[n:], uint64(len(entries)))
+	encRecord, err := db.encodeHintRecord(&data.LogRecord{Value: end[:n]})
+	if err != nil {
+		return err
+	}
+	buf = append(buf, encRecord...)
+
+	// 重新生成时替换损坏的hint文件
+	fileName := data.GetDataFileHintName(db.options.DirPath, fileId)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	hintFile, err := data.OpenDataFileHint(db.options.DirPath, fileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	return hintFile.Write(buf)
+}
+
+func (db *DB) encodeHintRecord(hintRecord *data.LogRecord) ([]byte, error) {
+	record, err := db.encryptLogRecord(hintRecord)
+	if err != nil {
+		return nil, err
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	return encRecord, nil
+}
+
+// 读取并校验数据文件的hint文件，返回保存的记录和去掉的记录大小
+func (db *DB) readDataFileHint(dataFile *data.DataFile) ([]*hintEntry, int64, error) {
+	fileName := data.GetDataFileHintName(db.options.DirPath, dataFile.FileId)
+	if _, err := os.Stat(fileName); err != nil {
+		return nil, 0, err
+	}
+	ioType := fio.StandardFIO
+	if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
+	}
+	hintFile, err := data.OpenDataFileHint(db.options.DirPath, dataFile.FileId, ioType)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer hintFile.Close()
+
+	reader, err := hintFile.NewLogRecordReader(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries []*hintEntry
+	for {
+		hintRecord, _, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, 0, errInvalidDataFileHint
+			}
+			return nil, 0, err
+		}
+		if err := db.decryptLogRecord(hintRecord); err != nil {
+			return nil, 0, err
+		}
+
+		// 最后一条记录，校验hint文件是否完整以及是否与数据文件对应
+		if len(hintRecord.Key) == 0 {
+			buf := hintRecord.Value
+			fileSize, n1 := binary.Varint(buf)
+			if n1 <= 0 {
+				return nil, 0, errInvalidDataFileHint
+			}
+			discardSize, n2 := binary.Varint(buf[n1:])
+			if n2 <= 0 {
+				return nil, 0, errInvalidDataFileHint
+			}
+			count, n3 := binary.Uvarint(buf[n1+n2:])
+			if n3 <= 0 || count != uint64(len(entries)) {
+				return nil, 0, errInvalidDataFileHint
+			}
+			if size, err := dataFile.IoManager.Size(); err != nil || size != fileSize {
+				return nil, 0, errInvalidDataFileHint
+			}
+			return entries, discardSize, nil
+		}
+
+		var pos, blobPos *data.LogRecordPos
+		if hintRecord.Flags&data.LogRecordBlob != 0 {
+			pos, blobPos = decodeBlobHint(hintRecord.Value)
+		} else {
+			pos = data.DecodeLogRecordPos(hintRecord.Value)
+		}
+		entries = append(entries, newHintEntry(hintRecord.Key, hintRecord.Type, pos, blobPos))
+	}
+}
+
+// 读取数据文件中offset之后的记录，返回最后一条完整记录之后的位置
+func (db *DB) scanDataFile(dataFile *data.DataFile, offset int64) ([]*hintEntry, int64, error) {
+	reader, err := dataFile.NewLogRecordReader(offset)
+	if err != nil {
+		return nil, offset, err
+	}
+	var entries []*hintEntry
+	for {
+		logRecord, size, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return entries, offset, nil
+			}
+			return entries, offset, err
+		}
+		// 回放只需要key，不需要解压value
+		if err := db.decryptLogRecord(logRecord); err != nil {
+			return entries, offset, err
+		}
+
+		pos := &data.LogRecordPos{
+			Fid:       dataFile.FileId,
+			Offset:    offset,
+			Size:      uint32(size),
+			ExpiresAt: logRecord.ExpiresAt,
+		}
+		var blobPos *data.LogRecordPos
+		if logRecord.Flags&data.LogRecordBlob != 0 {
+			blobPos = data.DecodeLogRecordPos(logRecord.Value)
+		}
+		entries = append(entries, newHintEntry(logRecord.Key, logRecord.Type, pos, blobPos))
+
+		// 读取下一条记录
+		offset += size
+	}
+}
+
+// 读取旧的数据文件中的记录，优先从hint文件读取，hint文件不存在或者损坏时读取数据文件并重新生成
+// 返回读取的记录、hint文件中去掉的记录大小以及最后一条完整记录之后的位置，不修改内存索引，可以并发调用
+func (db *DB) readOlderFileEntries(dataFile *data.DataFile) ([]*hintEntry, int64, int64, error) {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	entries, discardSize, err := db.readDataFileHint(dataFile)
+	if err == nil {
+		return entries, discardSize, size, nil
+	}
+
+	entries, offset, err := db.scanDataFile(dataFile, 0)
+	if err != nil || db.options.ReadOnly {
+		return entries, 0, offset, err
+	}
+
+	// 完整读取数据文件之后重新生成hint文件
+	if offset == size {
+		if err := db.writeDataFileHint(dataFile.FileId, size, entries); err != nil {
+			log.Printf("bitcask: failed to write the hint file of data file %d, %v", dataFile.FileId, err)
+		}
+	}
+	return entries, 0, offset, nil
+}
+
+func (db *DB) replayHintEntries(entries []*hintEntry, transactionRecords map[uint64][]*data.TransactionRecord, now int64) {
+	for _, entry := range entries {
+		db.replayLogRecord(entry.record, entry.pos, transactionRecords, now)
+	}
+}
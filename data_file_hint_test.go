@@ -0,0 +1,90 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_DataFileHint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = counterMergeOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%300), utils.RandomValue(64)))
+		if i%7 == 0 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i%300)))
+		}
+		if i%10 == 0 {
+			assert.Nil(t, db.MergeValue([]byte("counter"), []byte("1")))
+		}
+		if i%50 == 0 {
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for j := 0; j < 20; j++ {
+				assert.Nil(t, wb.Put(utils.GetTestKey(300+i/50*20+j), utils.RandomValue(64)))
+			}
+			assert.Nil(t, wb.Commit())
+		}
+	}
+
+	// 旧的数据文件都生成了hint文件
+	fileIds, err := listDataFileIds(dir)
+	assert.Nil(t, err)
+	assert.True(t, len(fileIds) > 3)
+	for i, fileId := range fileIds {
+		_, err := os.Stat(data.GetDataFileHintName(dir, uint32(fileId)))
+		assert.Equal(t, i < len(fileIds)-1, err == nil)
+	}
+
+	keys := db.ListKeys()
+	counter, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	reclaimSize := db.Stat().ReclaimableSize
+	check := func(db *DB) {
+		assert.Equal(t, keys, db.ListKeys())
+		val, err := db.Get([]byte("counter"))
+		assert.Nil(t, err)
+		assert.Equal(t, counter, val)
+		assert.Equal(t, reclaimSize, db.Stat().ReclaimableSize)
+	}
+	check(db)
+
+	// 从hint文件加载
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// hint文件缺失或者损坏时根据数据文件重新生成
+	assert.Nil(t, db.Close())
+	missing := data.GetDataFileHintName(dir, uint32(fileIds[0]))
+	assert.Nil(t, os.Remove(missing))
+	corrupted := data.GetDataFileHintName(dir, uint32(fileIds[1]))
+	buf, err := os.ReadFile(corrupted)
+	assert.Nil(t, err)
+	buf[len(buf)/2]++
+	assert.Nil(t, os.WriteFile(corrupted, buf, 0644))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	_, err = os.Stat(missing)
+	assert.Nil(t, err)
+	regenerated, err := os.ReadFile(corrupted)
+	assert.Nil(t, err)
+	assert.NotEqual(t, buf, regenerated)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
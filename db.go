@@ -8,42 +8,79 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/flock"
 )
 
 const (
-	seqNoKey     = "seq.no"
-	fileLockName = "flock"
+	seqNoKey       = "seq.no"
+	fileLockName   = "flock"
+	readerLockName = "flock-readers"
 )
 
 type DB struct {
-	options         Options
-	mu              *sync.RWMutex
-	fileIds         []int                     // 数据文件id,只用于加载索引
-	activeFile      *data.DataFile            // 当前活跃文件,可以写入
-	olderFiles      map[uint32]*data.DataFile // 旧文件,可以读取
-	index           index.Indexer             // 内存索引
-	seqNo           uint64                    // 事务序列号，全局递增
-	isMerging       bool                      // 是否正在合并
-	seqNoFileExists bool                      // 事务序列号文件是否存在
-	isInitial       bool                      // 是否是初始状态
-	fileLock        *flock.Flock              // 文件锁,用于防止多进程同时操作
-	bytesWrite      uint                      // 累计写入字节数
-	reclaimSize     int64                     // 无效数据大小
+	options               Options
+	mu                    *sync.RWMutex
+	fileIds               []int                                // 数据文件id,只用于加载索引
+	activeFile            *data.DataFile                       // 当前活跃文件,可以写入
+	olderFiles            map[uint32]*data.DataFile            // 旧文件,可以读取
+	index                 index.Indexer                        // 内存索引
+	seqNo                 uint64                               // 事务序列号，全局递增
+	isMerging             bool                                 // 是否正在合并
+	seqNoFileExists       bool                                 // 事务序列号文件是否存在
+	isInitial             bool                                 // 是否是初始状态
+	fileLock              *flock.Flock                         // 文件锁,用于防止多进程同时操作
+	bytesWrite            uint                                 // 累计写入字节数
+	reclaimSize           int64                                // 无效数据大小
+	snapshots             map[*Snapshot]struct{}               // 未释放的快照
+	operands              map[operandKey][]*data.LogRecordPos  // 操作数位置 -> 以它结尾的操作数链
+	staleOperands         [][]*data.LogRecordPos               // 等待快照释放后删除的操作数链
+	watchers              map[*watcher]struct{}                // 变更事件的监听者
+	droppedWatchEvents    uint64                               // 因缓冲区已满而丢弃的事件数量
+	deferWatchEvents      bool                                 // 组提交执行写入时为true，事件在持久化之后发送
+	pendingWatchEvents    [][]*WatchEvent                      // 组提交中等待持久化之后发送的事件
+	nonMergeFileId        uint32                               // 最近一次merge时的非merge文件id，小于它的数据文件都由merge生成
+	logUpdated            chan struct{}                        // 有新的日志写入时关闭，用于唤醒等待的变更流
+	changeStreams         map[*ChangeStream]struct{}           // 未关闭的变更流
+	appendCount           uint64                               // 累计追加的日志记录数量，用于组提交
+	syncer                *groupSyncer                         // 组提交，合并并发写入的持久化
+	syncFailed            bool                                 // 组提交持久化失败，内存索引中有未持久化的数据，之后的写入返回 ErrSyncFailed
+	mergedReclaimSize     int64                                // 最近一次merge完成时的无效数据大小，这部分数据在重启之后才会被清理
+	autoMerge             *autoMerger                          // 后台自动merge
+	closeCh               chan struct{}                        // 数据库关闭时关闭，用于停止后台任务
+	pendingTxnRecords     map[uint64][]*data.TransactionRecord // 只读模式下还没有读到完成标记的事务数据
+	cipher                *recordCipher                        // 记录加密，未开启加密时为空
+	activeHints           []*hintEntry                         // 活跃文件中的记录，活跃文件转为旧的数据文件时写入hint文件
+	activeHintsIncomplete bool                                 // activeHints 没有包含活跃文件中的全部记录，不生成hint文件
+	activeBlobFile        *data.DataFile                       // 当前活跃的blob文件，保存超过 ValueThreshold 的value
+	olderBlobFiles        map[uint32]*data.DataFile            // 旧的blob文件
+	blobRefs              map[operandKey]*data.LogRecordPos    // 有效的指针记录位置 -> blob记录位置
+	blobFilePins          int                                  // 正在拷贝blob文件的检查点和备份数量，大于0时不删除blob文件
+	cache                 *valueCache                          // 读取过的记录的缓存，未开启缓存时为空
+	bloom                 *index.BloomIndexer                  // 使用布隆过滤器的索引，未开启时为空
+	indexSnapshotWg       sync.WaitGroup                       // 后台定期保存索引快照
 }
 
 type Stat struct {
-	KeyNum          uint  // 键值对数量
-	DataFileNum     uint  // 数据文件数量
-	ReclaimableSize int64 // 可回收数据大小 B
-	DiskSize        int64 // 所占磁盘大小
+	KeyNum              uint          // 键值对数量
+	DataFileNum         uint          // 数据文件数量
+	ReclaimableSize     int64         // 可回收数据大小 B
+	DiskSize            int64         // 所占磁盘大小
+	DroppedWatchEvents  uint64        // 因监听者缓冲区已满而丢弃的事件数量
+	AutoMerge           AutoMergeStat // 后台自动merge的状态
+	BlobFileNum         uint          // blob文件数量
+	BlobReclaimableSize int64         // blob文件中可回收数据大小 B
+	CacheHits           uint64        // 读取记录时命中缓存的次数
+	CacheMisses         uint64        // 读取记录时没有命中缓存的次数
+	BloomAvoidedProbes  uint64        // 布隆过滤器判断key不存在，没有查找索引的次数
 }
 
 // 打开存储引擎实例
@@ -57,6 +94,9 @@ func Open(options Options) (*DB, error) {
 
 	// 判断目录是否存在
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
@@ -64,8 +104,17 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 判断当前目录是是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	// 只读模式对单独的锁文件加共享锁，多个只读实例可以与写入实例同时打开
+	var fileLock *flock.Flock
+	var hold bool
+	var err error
+	if options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, readerLockName))
+		hold, err = fileLock.TryRLock()
+	} else {
+		fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		hold, err = fileLock.TryLock()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +122,14 @@ func Open(options Options) (*DB, error) {
 		return nil, ErrDatabaseIsUsing
 	}
 
+	// 打开失败时释放文件锁
+	var opened bool
+	defer func() {
+		if !opened {
+			_ = fileLock.Unlock()
+		}
+	}()
+
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
@@ -83,16 +140,38 @@ func Open(options Options) (*DB, error) {
 
 	// 初始化DB实例
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+		options:        options,
+		mu:             new(sync.RWMutex),
+		olderFiles:     make(map[uint32]*data.DataFile),
+		index:          index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
+		isInitial:      isInitial,
+		fileLock:       fileLock,
+		snapshots:      make(map[*Snapshot]struct{}),
+		operands:       make(map[operandKey][]*data.LogRecordPos),
+		watchers:       make(map[*watcher]struct{}),
+		changeStreams:  make(map[*ChangeStream]struct{}),
+		syncer:         newGroupSyncer(),
+		autoMerge:      &autoMerger{},
+		closeCh:        make(chan struct{}),
+		olderBlobFiles: make(map[uint32]*data.DataFile),
+		blobRefs:       make(map[operandKey]*data.LogRecordPos),
+	}
+	if options.Encryption != nil {
+		db.cipher = newRecordCipher(options.Encryption)
+	}
+	if options.ValueCacheSize > 0 {
+		db.cache = newValueCache(options.ValueCacheSize)
+	}
+
+	// 加载merge数据目录，只读模式不能修改数据文件
+	if !options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
 	}
 
-	// 加载merge数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	// 在加载索引之前加入布隆过滤器
+	if err := db.initBloomFilter(); err != nil {
 		return nil, err
 	}
 
@@ -101,23 +180,38 @@ func Open(options Options) (*DB, error) {
 		return nil, err
 	}
 
+	// 加载blob文件
+	if err := db.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+
+	// 加载最近一次merge的位置
+	if err := db.loadNonMergeFileId(); err != nil {
+		return nil, err
+	}
+
 	// b+树不需要从数据文件加载索引
 	if options.IndexType != index.BPTree {
-		// 从hint文件中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+		// 从索引快照中加载索引，只需要回放快照之后的日志
+		loaded, startFid, startOffset := db.loadIndexSnapshot()
+		if !loaded {
+			// 从hint文件中加载索引
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return nil, err
+			}
+			startFid, startOffset = db.nonMergeFileId, 0
 		}
 
 		// 从数据文件中加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		if err := db.loadIndexFromDataFiles(startFid, startOffset); err != nil {
 			return nil, err
 		}
+	}
 
-		// 重置IO类型为标准IO
-		if db.options.MMapAtStartup {
-			if err := db.resetIoType(); err != nil {
-				return nil, err
-			}
+	// 重置IO类型为标准IO
+	if db.options.MMapAtStartup {
+		if err := db.resetIoType(); err != nil {
+			return nil, err
 		}
 	}
 
@@ -126,7 +220,7 @@ func Open(options Options) (*DB, error) {
 		if err := db.loadSeqNo(); err != nil {
 			return nil, err
 		}
-		if db.activeFile == nil {
+		if db.activeFile != nil {
 			size, err := db.activeFile.IoManager.Size()
 			if err != nil {
 				return nil, err
@@ -135,6 +229,17 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 开启后台自动merge
+	if options.AutoMergeInterval > 0 && !options.ReadOnly {
+		db.startAutoMerge()
+	}
+
+	// 开启后台定期保存索引快照
+	if options.IndexSnapshot && options.IndexSnapshotInterval > 0 && !options.ReadOnly {
+		db.startIndexSnapshot()
+	}
+
+	opened = true
 	return db, nil
 }
 
@@ -146,6 +251,9 @@ func (db *DB) Close() error {
 		}
 	}()
 
+	// 停止后台任务
+	db.stopBackgroundTasks()
+
 	if db.activeFile == nil {
 		return nil
 	}
@@ -153,27 +261,83 @@ func (db *DB) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 关闭所有监听者
+	for w := range db.watchers {
+		db.closeWatcher(w)
+	}
+
+	// 关闭所有变更流
+	for cs := range db.changeStreams {
+		db.closeChangeStream(cs)
+	}
+
+	// 保存索引快照，下次打开时只需要回放之后的日志
+	if db.options.IndexSnapshot && !db.options.ReadOnly {
+		snapshot, err := db.captureIndexSnapshot()
+		if err != nil {
+			return err
+		}
+		if err := db.writeIndexSnapshot(snapshot); err != nil {
+			return err
+		}
+	}
+
 	if err := db.index.Close(); err != nil {
 		return err
 	}
 
+	// 只读模式不写入事务序列号文件
+	if db.options.ReadOnly {
+		return db.closeDataFiles()
+	}
+
 	// 保存当前事务序列号
-	seqNofile, err := data.OpenSeqNoFile(db.options.DirPath)
+	if err := db.writeSeqNoFile(db.options.DirPath, db.seqNo); err != nil {
+		return err
+	}
+
+	// 保存布隆过滤器，下次打开时不需要重建
+	if err := db.saveBloomFilter(); err != nil {
+		return err
+	}
+
+	return db.closeDataFiles()
+}
+
+// 写入事务序列号文件
+func (db *DB) writeSeqNoFile(dirPath string, seqNo uint64) error {
+	encRecord, err := db.encodeSeqNoRecord(seqNo)
 	if err != nil {
 		return err
 	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+
+	seqNofile, err := data.OpenSeqNoFile(dirPath)
+	if err != nil {
+		return err
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
+	defer seqNofile.Close()
+
 	if err := seqNofile.Write(encRecord); err != nil {
 		return err
 	}
-	if err := seqNofile.Sync(); err != nil {
-		return err
+	return seqNofile.Sync()
+}
+
+// 编码事务序列号文件的内容
+func (db *DB) encodeSeqNoRecord(seqNo uint64) ([]byte, error) {
+	record, err := db.encryptLogRecord(&data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
+	})
+	if err != nil {
+		return nil, err
 	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	return encRecord, nil
+}
 
+// 关闭所有数据文件
+func (db *DB) closeDataFiles() error {
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
@@ -183,7 +347,7 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
-	return nil
+	return db.closeBlobFiles()
 }
 
 // 持久化活跃文件
@@ -194,6 +358,10 @@ func (db *DB) Sync() error {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	// 先持久化blob文件，指针记录不能早于它引用的value持久化
+	if err := db.syncBlobFile(); err != nil {
+		return err
+	}
 	return db.activeFile.Sync()
 }
 
@@ -207,10 +375,39 @@ func checkOptions(options Options) error {
 		return errors.New("the data file size is invalid")
 	}
 
+	if options.Encryption != nil && options.IndexType == BPlusTree {
+		return errors.New("the b+ tree index stores plaintext keys on disk and does not support encryption")
+	}
+
+	if options.ReadOnly && options.IndexType == BPlusTree {
+		return errors.New("the b+ tree index does not support read only mode")
+	}
+
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("the data file merge ratio is invalid")
 	}
 
+	if options.ValueThreshold > 0 && options.IndexType == BPlusTree {
+		return errors.New("the b+ tree index does not support value separation")
+	}
+
+	if options.BlobMergeRatio < 0 || options.BlobMergeRatio > 1 {
+		return errors.New("the blob merge ratio is invalid")
+	}
+
+	if options.BloomFilterFalsePositiveRate >= 1 {
+		return errors.New("the bloom filter false positive rate is invalid")
+	}
+
+	if options.IndexSnapshot && options.IndexType == BPlusTree {
+		return errors.New("the b+ tree index does not support index snapshot")
+	}
+
+	if options.AutoMergeWindowStart < 0 || options.AutoMergeWindowStart >= 24*time.Hour ||
+		options.AutoMergeWindowEnd < 0 || options.AutoMergeWindowEnd >= 24*time.Hour {
+		return errors.New("the auto merge window is invalid")
+	}
+
 	return nil
 }
 
@@ -223,70 +420,121 @@ func (db *DB) Stat() *Stat {
 	if db.activeFile != nil {
 		dataFiles++
 	}
+	var blobFiles = uint(len(db.olderBlobFiles))
+	if db.activeBlobFile != nil {
+		blobFiles++
+	}
 
 	dirSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
 		panic(fmt.Sprintf("failed to get the directory size, %v", err))
 	}
+	var cacheHits, cacheMisses uint64
+	if db.cache != nil {
+		cacheHits, cacheMisses = db.cache.stat()
+	}
+	var bloomAvoidedProbes uint64
+	if db.bloom != nil {
+		bloomAvoidedProbes = db.bloom.AvoidedProbes()
+	}
 	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimableSize:     db.reclaimSize,
+		DiskSize:            dirSize,
+		DroppedWatchEvents:  db.droppedWatchEvents,
+		AutoMerge:           db.autoMerge.stat(),
+		BlobFileNum:         blobFiles,
+		BlobReclaimableSize: db.blobReclaimableSize(),
+		CacheHits:           cacheHits,
+		CacheMisses:         cacheMisses,
+		BloomAvoidedProbes:  bloomAvoidedProbes,
 	}
 }
 
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName, readerLockName})
 }
 
 // 写入 kv，不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.PutWithTTL(key, value, 0)
+}
+
+// 写入带过期时间的 kv，ttl <= 0 表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
 	// Check if the key is empty
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	expiresAt := expiresAtFromTTL(ttl)
+	return db.writeWithSync(db.options.SyncWrites, func() error {
+		return db.put(key, value, expiresAt)
+	})
+}
+
+// 写入数据并更新内存索引，调用方需要持有db.mu
+func (db *DB) put(key []byte, value []byte, expiresAt int64) error {
 	logRecord := data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		ExpiresAt: expiresAt,
 	}
 
 	// 追加写入活跃文件
-	pos, err := db.appendLogRecordWithLock(&logRecord)
+	pos, err := db.appendLogRecord(&logRecord)
 	if err != nil {
 		return err
 	}
 	// 更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
+	db.discardPos(db.index.Put(key, pos))
+	db.notifyWatch(WatchEventPut, key, value)
 
 	return nil
 }
 
-// 获得所有key
+// 获得所有key，已过期的key不返回
 func (db *DB) ListKeys() [][]byte {
+	// 持有db.mu创建迭代器，不会读到组提交中还没有持久化的写入
+	db.mu.RLock()
 	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	db.mu.RUnlock()
+	defer iterator.Close()
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
 
 // 获得所有数据，并执行指定操作，fn返回false则停止遍历
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.foldIndex(db.index, time.Now().UnixNano(), fn)
+}
+
+// 遍历指定索引中在 now 时刻未过期的数据
+func (db *DB) foldIndex(idx index.Indexer, now int64, fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.foldIndexLocked(idx, now, fn)
+}
 
-	iterator := db.index.Iterator(false)
+// 持有db.mu时遍历索引中的数据
+func (db *DB) foldIndexLocked(idx index.Indexer, now int64, fn func(key []byte, value []byte) bool) error {
+	iterator := idx.Iterator(false)
+	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			continue
@@ -302,6 +550,29 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // 根据位置读取数据
 func (db *DB) getValueByPosition(logRecordpos *data.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.readLogRecordAt(logRecordpos)
+	if err != nil {
+		return nil, err
+	}
+
+	switch logRecord.Type {
+	case data.LogRecordDeleted:
+		return nil, ErrKeyNotFound
+	case data.LogRecordMerge:
+		// 操作数需要与之前的值合并
+		chain := db.operands[newOperandKey(logRecordpos)]
+		if chain == nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		return db.mergeOperands(realKey, chain)
+	}
+
+	return logRecord.Value, nil
+}
+
+// 根据位置从数据文件中读取日志记录，不使用缓存
+func (db *DB) readLogRecordFromFile(logRecordpos *data.LogRecordPos) (*data.LogRecord, error) {
 
 	// 根据文件id找到数据文件
 	var dataFile *data.DataFile
@@ -316,17 +587,30 @@ func (db *DB) getValueByPosition(logRecordpos *data.LogRecordPos) ([]byte, error
 		return nil, ErrDataFileNotFound
 	}
 
-	// 根据偏移量读取数据
-	logRecord, _, err := dataFile.ReadLogRecord(logRecordpos.Offset)
+	// 根据偏移量读取数据，value保存在blob文件中时读取完整的value
+	logRecord, _, err := db.readLogRecord(dataFile, logRecordpos.Offset)
 	if err != nil {
 		return nil, err
 	}
-
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
+	if err := db.readBlobValue(logRecord); err != nil {
+		return nil, err
 	}
+	return logRecord, nil
+}
 
-	return logRecord.Value, nil
+// 从数据文件中读取日志记录，并解密、解压
+func (db *DB) readLogRecord(dataFile *data.DataFile, offset int64) (*data.LogRecord, int64, error) {
+	logRecord, size, err := dataFile.ReadLogRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := db.decryptLogRecord(logRecord); err != nil {
+		return nil, 0, err
+	}
+	if err := db.decompressLogRecord(logRecord); err != nil {
+		return nil, 0, err
+	}
+	return logRecord, size, nil
 }
 
 // 获得数据
@@ -337,12 +621,7 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 
-	// 从内存索引中查找
-	logRecordpos := db.index.Get(key)
-	if logRecordpos == nil {
-		return nil, ErrKeyNotFound
-	}
-	return db.getValueByPosition(logRecordpos)
+	return db.getLocked(key)
 }
 
 // 删除数据
@@ -352,11 +631,17 @@ func (db *DB) Delete(key []byte) error {
 		return ErrKeyIsEmpty
 	}
 
-	// 从内存索引中查找, 不存在
-	if pos := db.index.Get(key); pos == nil {
-		return nil
-	}
+	return db.writeWithSync(db.options.SyncWrites, func() error {
+		// 从内存索引中查找, 不存在
+		if pos := db.index.Get(key); pos == nil {
+			return nil
+		}
+		return db.delete(key)
+	})
+}
 
+// 写入删除标记并更新内存索引，调用方需要持有db.mu
+func (db *DB) delete(key []byte) error {
 	// 构造logrecord，标记为删除
 	logRecord := &data.LogRecord{
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
@@ -364,7 +649,7 @@ func (db *DB) Delete(key []byte) error {
 	}
 
 	// 追加写入活跃文件
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
@@ -375,19 +660,12 @@ func (db *DB) Delete(key []byte) error {
 	if !ok {
 		return ErrIndexUpdataFailed
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
+	db.discardPos(oldPos)
+	db.notifyWatch(WatchEventDelete, key, nil)
 
 	return nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
-}
-
 // 追加写到活跃文件中
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 
@@ -398,21 +676,30 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
-
-	// 如果写入文件达到活跃文件的阈值，则关闭活跃文件，并打开新的文件
-	if int64(db.activeFile.WriteOff)+size > db.options.DataFileSize {
-		// 持久化数据文件到磁盘
-		if err := db.activeFile.Sync(); err != nil {
+	// 大value写入blob文件，数据文件中只保存指针记录
+	record := logRecord
+	var blobPos *data.LogRecordPos
+	if db.isLargeValue(logRecord) {
+		var err error
+		if record, blobPos, err = db.separateValue(logRecord); err != nil {
 			return nil, err
 		}
+	}
 
-		// 当前活跃文件转化为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
+	// 按照配置压缩和加密之后编码
+	record, err := db.compressLogRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if record, err = db.encryptLogRecord(record); err != nil {
+		return nil, err
+	}
+	encRecord, size := data.EncodeLogRecord(record)
 
-		// 打开新的活跃文件
-		if err := db.setActiveDataFile(); err != nil {
+	// 如果写入文件达到活跃文件的阈值，则关闭活跃文件，并打开新的文件
+	if int64(db.activeFile.WriteOff)+size > db.options.DataFileSize {
+		// 当前活跃文件转化为旧的数据文件，并打开新的活跃文件
+		if err := db.sealActiveFile(); err != nil {
 			return nil, err
 		}
 	}
@@ -423,13 +710,19 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	db.bytesWrite += uint(size)
+	db.appendCount++
 
-	// 根据用户配置决定是否持久化
-	var needSync = db.options.SyncWrites
-	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
-		needSync = true
+	// 唤醒等待新日志的变更流
+	if db.logUpdated != nil {
+		close(db.logUpdated)
+		db.logUpdated = nil
 	}
-	if needSync {
+
+	// 根据用户配置决定是否持久化，SyncWrites 由写入方法通过组提交持久化
+	if db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
+		if err := db.syncBlobFile(); err != nil {
+			return nil, err
+		}
 		if err := db.activeFile.Sync(); err != nil {
 			return nil, err
 		}
@@ -439,7 +732,16 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	// 构造内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
+	pos := &data.LogRecordPos{
+		Fid:       db.activeFile.FileId,
+		Offset:    writeOff,
+		Size:      uint32(size),
+		ExpiresAt: logRecord.ExpiresAt,
+	}
+	if blobPos != nil {
+		db.addBlobRef(pos, blobPos)
+	}
+	db.trackHintEntry(newHintEntry(logRecord.Key, logRecord.Type, pos, blobPos))
 	return pos, nil
 }
 
@@ -461,27 +763,10 @@ func (db *DB) setActiveDataFile() error {
 
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEmtries, err := os.ReadDir(db.options.DirPath)
+	fileIds, err := listFileIds(db.options.DirPath, data.DataFileNameSuffix)
 	if err != nil {
 		return err
 	}
-
-	var fileIds []int
-	// 遍历目录中以.data结尾的文件
-	for _, entry := range dirEmtries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
-			// 获取文件id
-			splitName := strings.Split(entry.Name(), ".")
-			fileId, err := strconv.Atoi(splitName[0])
-			if err != nil {
-				return ErrDataDirectoryCorrupted
-			}
-			fileIds = append(fileIds, fileId)
-		}
-	}
-
-	// 排序文件id
-	sort.Ints(fileIds)
 	db.fileIds = fileIds
 
 	// 遍历文件id，打开数据文件
@@ -505,113 +790,220 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
+// 获取目录中所有数据文件的id，按从小到大排序
+func listDataFileIds(dirPath string) ([]int, error) {
+	return listFileIds(dirPath, data.DataFileNameSuffix)
+}
+
+// 获取目录中所有以 suffix 结尾的文件的id，按从小到大排序
+func listFileIds(dirPath string, suffix string) ([]int, error) {
+	dirEmtries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []int
+	// 遍历目录中以 suffix 结尾的文件
+	for _, entry := range dirEmtries {
+		if strings.HasSuffix(entry.Name(), suffix) {
+			// 获取文件id
+			splitName := strings.Split(entry.Name(), ".")
+			fileId, err := strconv.Atoi(splitName[0])
+			if err != nil {
+				return nil, ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	// 排序文件id
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
 // 从数据文件中加载索引
-func (db *DB) loadIndexFromDataFiles() error {
+// 从 startFid 文件的 startOffset 处开始回放，之前的日志已经从hint文件或者索引快照中加载
+func (db *DB) loadIndexFromDataFiles(startFid uint32, startOffset int64) error {
 	// 说明数据库为空
 	if len(db.fileIds) == 0 {
 		return nil
 	}
 
-	// 查看是否发生过merge
-	hasMerge, nonMergeFileId := false, uint32(0)
-	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); !os.IsNotExist(err) {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
-		if err != nil {
-			return err
+	// 需要读取的数据文件，并发读取之后按照文件id的顺序更新索引
+	var jobs []*replayJob
+	for i, fileId := range db.fileIds {
+		var fileId = uint32(fileId)
+
+		// 已经从hint文件或者索引快照加载过索引，则跳过
+		if fileId < startFid {
+			continue
 		}
-		hasMerge = true
-		nonMergeFileId = fid
-	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+		job := &replayJob{isActive: i == len(db.fileIds)-1, done: make(chan struct{})}
+		if job.isActive {
+			job.dataFile = db.activeFile
 		} else {
-			oldPos = db.index.Put(key, pos)
+			job.dataFile = db.olderFiles[fileId]
 		}
-
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+		if fileId == startFid {
+			job.offset = startOffset
 		}
+		jobs = append(jobs, job)
 	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	pool := db.startReplayPool(jobs)
+	defer pool.stop()
 
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
+	now := time.Now().UnixNano()
+
+	for _, job := range jobs {
+		pool.wait(job)
+		db.replayHintEntries(job.entries, transactionRecords, now)
+		db.reclaimSize += job.discardSize
+		if job.isActive {
+			// 活跃文件从快照之后开始回放时，没有记录之前的记录，不生成hint文件
+			db.activeHintsIncomplete = job.dataFile.FileId == startFid && startOffset > 0
+			for _, entry := range job.entries {
+				db.trackHintEntry(entry)
+			}
+		}
+		pool.release(job)
 
-	// 遍历数据文件，加载索引
-	for i, fileId := range db.fileIds {
-		var fileId = uint32(fileId)
+		if err := db.recoverDataFile(job.dataFile, job.offset, job.err, job.isActive); err != nil {
+			return err
+		}
 
-		// 已经从hint文件加载过索引，则跳过
-		if hasMerge && fileId < nonMergeFileId {
-			continue
+		// 最后一个文件,说明为活跃文件
+		if job.isActive {
+			db.activeFile.WriteOff = job.offset
 		}
+	}
 
-		var dataFile *data.DataFile
+	// 只读模式下保留还没有读到完成标记的事务数据，刷新时继续回放
+	if db.options.ReadOnly {
+		db.pendingTxnRecords = transactionRecords
+	}
 
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.olderFiles[fileId]
-		}
+	return nil
+}
+
+// 处理回放之后数据文件中offset之后无法读取的数据
+// 活跃文件末尾不完整的记录由写入时崩溃造成，截断到最后一条完整的记录，旧的数据文件按照 RecoveryMode 处理
+// 活跃文件开头或者中间损坏时返回错误
+func (db *DB) recoverDataFile(dataFile *data.DataFile, offset int64, replayErr error, isActive bool) error {
+	if replayErr != nil && replayErr != data.ErrInvalidCRC {
+		return replayErr
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if offset >= size {
+		return nil
+	}
 
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+	mode := db.options.RecoveryMode
+	switch {
+	case isActive && db.options.ReadOnly:
+		// 只读模式不能修改数据文件，写入实例可能正在写最后一条记录
+		return nil
+	case isActive && mode != RecoveryStrict && offset > 0:
+		// 损坏的数据之后还有完整的记录时不是写入时崩溃造成的，截断会丢失之后的记录
+		if _, err := dataFile.FindLogRecord(offset + 1); err != io.EOF {
 			if err != nil {
-				if err == io.EOF {
-					break
-				}
 				return err
 			}
-
-			// 构建内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
-
-			// 解析key，拿到序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				// 非事务操作，直接更新索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
-			}
-
-			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+			break
+		}
+		log.Printf("bitcask: truncate the corrupted tail of data file %d, dropped %d bytes from offset %d",
+			dataFile.FileId, size-offset, offset)
+		// 内存映射只能读取，截断之前切换为标准IO
+		if db.options.MMapAtStartup {
+			if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+				return err
 			}
+		}
+		return dataFile.Truncate(offset)
+	case !isActive && mode == RecoverySkipCorrupted:
+		log.Printf("bitcask: skip the corrupted data in data file %d, ignored %d bytes from offset %d",
+			dataFile.FileId, size-offset, offset)
+		return nil
+	}
+	return fmt.Errorf("%w: data file %d is corrupted at offset %d", ErrDataDirectoryCorrupted, dataFile.FileId, offset)
+}
 
-			// 读取下一条记录
-			offset += size
+// 从数据文件的offset处开始回放日志记录并更新内存索引，返回最后一条完整记录之后的位置
+func (db *DB) replayDataFile(dataFile *data.DataFile, offset int64,
+	transactionRecords map[uint64][]*data.TransactionRecord, now int64) (int64, error) {
+	entries, offset, err := db.scanDataFile(dataFile, offset)
+	db.replayHintEntries(entries, transactionRecords, now)
+	if dataFile == db.activeFile {
+		for _, entry := range entries {
+			db.trackHintEntry(entry)
 		}
+	}
+	return offset, err
+}
 
-		// 最后一个文件,说明为活跃文件
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+// 回放一条日志记录，事务记录暂存到读到完成标记时再更新索引
+func (db *DB) replayLogRecord(logRecord *data.LogRecord, pos *data.LogRecordPos,
+	transactionRecords map[uint64][]*data.TransactionRecord, now int64) {
+	// 解析key，拿到序列号
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if seqNo == nonTransactionSeqNo {
+		// 非事务操作，直接更新索引
+		db.updateIndex(realKey, logRecord, pos, now)
+	} else if logRecord.Type == data.LogRecordTxnFinished {
+		for _, txnRecord := range transactionRecords[seqNo] {
+			db.updateIndex(txnRecord.Record.Key, txnRecord.Record, txnRecord.Pos, now)
 		}
+		delete(transactionRecords, seqNo)
+	} else {
+		txnRecord := *logRecord
+		txnRecord.Key = realKey
+		transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+			Record: &txnRecord,
+			Pos:    pos,
+		})
 	}
 
-	// 更新序列号
-	db.seqNo = currentSeqNo
+	// 更新事务序列号
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+}
 
-	return nil
+// 根据回放的日志记录更新内存索引
+func (db *DB) updateIndex(key []byte, logRecord *data.LogRecord, pos *data.LogRecordPos, now int64) {
+	var oldPos *data.LogRecordPos
+	typ := logRecord.Type
+	// 已过期的数据与删除同等处理
+	if typ == data.LogRecordDeleted || pos.IsExpired(now) {
+		oldPos, _ = db.index.Delete(key)
+		db.reclaimSize += int64(pos.Size)
+	} else if typ == data.LogRecordMerge {
+		// 重建操作数链
+		var chain []*data.LogRecordPos
+		if curPos := db.index.Get(key); curPos != nil {
+			if chain = db.operands[newOperandKey(curPos)]; chain == nil {
+				chain = []*data.LogRecordPos{curPos}
+			}
+		}
+		db.addOperand(key, chain, pos)
+		return
+	} else {
+		oldPos = db.index.Put(key, pos)
+		// 重建指针记录对blob记录的引用
+		if logRecord.Flags&data.LogRecordBlob != 0 {
+			db.addBlobRef(pos, data.DecodeLogRecordPos(logRecord.Value))
+		}
+	}
 
+	db.discardPos(oldPos)
 }
 
 func (db *DB) loadSeqNo() error {
@@ -629,6 +1021,9 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
+	if err := db.decryptLogRecord(record); err != nil {
+		return err
+	}
 
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
@@ -640,6 +1035,14 @@ func (db *DB) loadSeqNo() error {
 	return nil
 }
 
+// 根据ttl计算过期时间
+func expiresAtFromTTL(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
 // 将数据文件的IO类型设置为标准IO
 func (db *DB) resetIoType() error {
 	if db.activeFile == nil {
@@ -1,8 +1,11 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -388,3 +391,102 @@ func TestDB_OpenMMap(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.未过期时可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	// 2.过期之后 Get、ListKeys、Iterator、Fold 都不可见
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(2)}, db.ListKeys())
+
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	var iterKeys [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		iterKeys = append(iterKeys, iterator.Key())
+	}
+	iterator.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(2)}, iterKeys)
+
+	var foldNum int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldNum++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, foldNum)
+
+	// 3.重启之后过期数据不会加载到索引中
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+	defer destroyDB(db2)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint(1), db2.Stat().KeyNum)
+}
+
+func TestOpen_BaselineFormat(t *testing.T) {
+	// testdata/baseline 中的数据文件由没有过期时间和标记字段的旧版本写入
+	dir, _ := os.MkdirTemp("", "bitcask-go-baseline")
+	buf, err := os.ReadFile(filepath.Join("testdata", "baseline", "000000000.data"))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, 0), buf, 0644))
+
+	opts := DefaultOptions
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 旧格式的数据没有被当作损坏的末尾截断
+	stat, err := os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)), stat.Size())
+
+	expected := map[string]string{
+		"batch-0": "batch-value-0",
+		"batch-1": "batch-value-1",
+		"batch-2": "batch-value-2",
+	}
+	for i := 0; i < 10; i++ {
+		if i != 3 && i != 4 {
+			expected[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("value-%d", i)
+		}
+	}
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(val))
+	}
+
+	// 新写入的数据与旧格式的数据可以一起重新加载
+	assert.Nil(t, db.PutWithTTL([]byte("ttl-key"), []byte("ttl-value"), time.Hour))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected)+1, len(db.ListKeys()))
+	val, err := db.Get([]byte("key-0"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-0", string(val))
+}
@@ -0,0 +1,187 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// 加密密钥的提供者，每个密钥使用id区分，id对应的密钥不能修改
+// 轮换密钥时 CurrentKey 返回新的密钥，旧的密钥需要保留到merge将旧数据重新加密为止
+type KeyProvider interface {
+	// 加密新记录使用的密钥及其id，密钥长度为16、24或32字节，分别对应AES-128、AES-192、AES-256
+	CurrentKey() (uint32, []byte, error)
+	// 根据id返回解密使用的密钥
+	Key(id uint32) ([]byte, error)
+}
+
+// 固定密钥集合的 KeyProvider
+type StaticKeyProvider struct {
+	Keys      map[uint32][]byte // 所有密钥
+	CurrentID uint32            // 加密新记录使用的密钥id
+}
+
+func (kp *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := kp.Key(kp.CurrentID)
+	return kp.CurrentID, key, err
+}
+
+func (kp *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := kp.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %d not found", id)
+	}
+	return key, nil
+}
+
+// 使用AES-GCM加密日志记录的key和value
+// 加密之后的记录 key 为 密钥id + nonce，value 为 key长度 + key + value 的密文，
+// 记录类型、标记和过期时间作为附加数据参与认证
+type recordCipher struct {
+	provider KeyProvider
+	mu       sync.RWMutex
+	aeads    map[uint32]cipher.AEAD
+}
+
+func newRecordCipher(provider KeyProvider) *recordCipher {
+	return &recordCipher{
+		provider: provider,
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+}
+
+// 获取密钥id对应的AEAD，key 为空时从 KeyProvider 获取密钥
+func (rc *recordCipher) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	rc.mu.RLock()
+	aead, ok := rc.aeads[id]
+	rc.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	if key == nil {
+		var err error
+		if key, err = rc.provider.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	rc.mu.Lock()
+	rc.aeads[id] = aead
+	rc.mu.Unlock()
+	return aead, nil
+}
+
+func (rc *recordCipher) encrypt(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	id, key, err := rc.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := rc.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, binary.MaxVarintLen32+aead.NonceSize())
+	n := binary.PutUvarint(header, uint64(id))
+	header = header[:n+aead.NonceSize()]
+	if _, err := rand.Read(header[n:]); err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32+len(logRecord.Key)+len(logRecord.Value))
+	plaintext = plaintext[:binary.PutUvarint(plaintext, uint64(len(logRecord.Key)))]
+	plaintext = append(plaintext, logRecord.Key...)
+	plaintext = append(plaintext, logRecord.Value...)
+
+	record := *logRecord
+	record.Key = header
+	record.Value = aead.Seal(nil, header[n:], plaintext, additionalData(logRecord))
+	record.Flags |= data.LogRecordEncrypted
+	return &record, nil
+}
+
+func (rc *recordCipher) decrypt(logRecord *data.LogRecord) error {
+	id, n := binary.Uvarint(logRecord.Key)
+	if n <= 0 {
+		return ErrDataDirectoryCorrupted
+	}
+	aead, err := rc.aead(uint32(id), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWrongEncryptionKey, err)
+	}
+	if len(logRecord.Key) != n+aead.NonceSize() {
+		return ErrDataDirectoryCorrupted
+	}
+
+	logRecord.Flags &^= data.LogRecordEncrypted
+	plaintext, err := aead.Open(nil, logRecord.Key[n:], logRecord.Value, additionalData(logRecord))
+	if err != nil {
+		return fmt.Errorf("%w: key id %d", ErrWrongEncryptionKey, id)
+	}
+
+	keySize, m := binary.Uvarint(plaintext)
+	if m <= 0 || uint64(len(plaintext)-m) < keySize {
+		return ErrDataDirectoryCorrupted
+	}
+	logRecord.Key = plaintext[m : m+int(keySize)]
+	logRecord.Value = plaintext[m+int(keySize):]
+	return nil
+}
+
+// 参与认证的附加数据，防止记录类型和过期时间被修改
+func additionalData(logRecord *data.LogRecord) []byte {
+	buf := make([]byte, 2+binary.MaxVarintLen64)
+	buf[0] = logRecord.Type
+	buf[1] = logRecord.Flags &^ data.LogRecordEncrypted
+	n := binary.PutVarint(buf[2:], logRecord.ExpiresAt)
+	return buf[:2+n]
+}
+
+// 按照配置加密写入的记录
+func (db *DB) encryptLogRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.cipher == nil {
+		return logRecord, nil
+	}
+	return db.cipher.encrypt(logRecord)
+}
+
+// 解密读取的记录，未加密的记录保持不变
+func (db *DB) decryptLogRecord(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.LogRecordEncrypted == 0 {
+		return nil
+	}
+	if db.cipher == nil {
+		return ErrEncryptionKeyRequired
+	}
+	return db.cipher.decrypt(logRecord)
+}
+
+// 写入hint索引记录，blobPos 不为空时同时记录指针记录引用的blob记录
+func (db *DB) writeHintRecord(hintFile *data.DataFile, key []byte, pos *data.LogRecordPos, blobPos *data.LogRecordPos) error {
+	hintRecord := &data.LogRecord{
+		Key:   key,
+		Value: data.EncodeLogRecordPos(pos),
+	}
+	if blobPos != nil {
+		hintRecord.Value = encodeBlobHint(pos, blobPos)
+		hintRecord.Flags = data.LogRecordBlob
+	}
+	record, err := db.encryptLogRecord(hintRecord)
+	if err != nil {
+		return err
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	return hintFile.Write(encRecord)
+}
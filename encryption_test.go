@@ -0,0 +1,183 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeyProvider(current uint32, ids ...uint32) *StaticKeyProvider {
+	kp := &StaticKeyProvider{Keys: make(map[uint32][]byte), CurrentID: current}
+	for _, id := range ids {
+		kp.Keys[id] = bytes.Repeat([]byte{byte(id)}, 32)
+	}
+	return kp
+}
+
+// 数据目录中的任何文件是否包含明文
+func dirContains(t *testing.T, dir string, plaintext []byte) bool {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		buf, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		if bytes.Contains(buf, plaintext) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.Encryption = testKeyProvider(1, 1)
+	opts.Compression = FlateCompression
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	secretValue := bytes.Repeat([]byte("secret-value "), 20)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put([]byte("secret-key-"+string(utils.GetTestKey(i))), secretValue))
+	}
+	for i := 0; i < 250; i++ {
+		assert.Nil(t, db.Delete([]byte("secret-key-"+string(utils.GetTestKey(i)))))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("secret-key-batch"), secretValue))
+	assert.Nil(t, wb.Commit())
+
+	// 内存索引中保存明文key，迭代顺序不变
+	keys := db.ListKeys()
+	assert.Equal(t, 251, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 数据文件、hint文件和事务序列号文件都不包含明文
+	assert.False(t, dirContains(t, dir, []byte("secret-key-")))
+	assert.False(t, dirContains(t, dir, []byte("secret-value")))
+	assert.False(t, dirContains(t, dir, []byte(seqNoKey)))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 251, len(db.ListKeys()))
+	val, err := db.Get([]byte("secret-key-batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, secretValue, val)
+	_, err = db.Get([]byte("secret-key-" + string(utils.GetTestKey(0))))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.False(t, dirContains(t, dir, []byte("secret-key-")))
+}
+
+func TestDB_Encryption_WrongKey(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-wrong-key")
+	opts.DirPath = dir
+	opts.Encryption = testKeyProvider(1, 1)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Close())
+	size, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+
+	// 错误的密钥和缺少密钥时打开失败，数据文件不会被截断
+	wrongOpts := opts
+	wrongOpts.Encryption = &StaticKeyProvider{Keys: map[uint32][]byte{1: bytes.Repeat([]byte{9}, 32)}, CurrentID: 1}
+	_, err = Open(wrongOpts)
+	assert.True(t, errors.Is(err, ErrWrongEncryptionKey))
+
+	wrongOpts.Encryption = testKeyProvider(2, 2)
+	_, err = Open(wrongOpts)
+	assert.True(t, errors.Is(err, ErrWrongEncryptionKey))
+
+	wrongOpts.Encryption = nil
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+	newSize, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, size, newSize)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+}
+
+func TestDB_Encryption_KeyRotation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-rotation")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Encryption = testKeyProvider(1, 1)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value-1")))
+	}
+	assert.Nil(t, db.Close())
+
+	// 轮换密钥之后新写入的数据使用新的密钥，旧数据仍然可以读取
+	opts.Encryption = testKeyProvider(2, 1, 2)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 50; i < 150; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value-2")))
+	}
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+	assert.Nil(t, db.Close())
+
+	// merge之前旧的密钥不能删除
+	onlyNewKey := opts
+	onlyNewKey.Encryption = testKeyProvider(2, 2)
+	_, err = Open(onlyNewKey)
+	assert.True(t, errors.Is(err, ErrWrongEncryptionKey))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// merge使用新的密钥重新加密旧数据
+	db, err = Open(onlyNewKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 150, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+	val, err = db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-2"), val)
+}
+
+func TestDB_Encryption_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-bptree")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.Encryption = testKeyProvider(1, 1)
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
@@ -13,4 +13,29 @@ var (
 	ErrDatabaseIsUsing        = errors.New("database directory is using, try again later")
 	ErrMergeRatioUnreached    = errors.New("merge ratio is not reached")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough space for merge")
+	ErrSnapshotUnsupported    = errors.New("snapshots are only supported by the btree index")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, keys read in the transaction have been modified")
+	ErrTxnFinished            = errors.New("transaction has already been committed or rolled back")
+	ErrKeyAlreadyExists       = errors.New("key already exists")
+	ErrValueNotMatch          = errors.New("current value does not match the expected value")
+	ErrMergeOperatorNotSet    = errors.New("merge operator is not set in options")
+	ErrCursorCompacted        = errors.New("the log position has been compacted away by merge or blob compaction")
+	ErrInvalidLogPosition     = errors.New("invalid log position")
+	ErrChangeStreamClosed     = errors.New("the change stream is closed")
+	ErrMergeAborted           = errors.New("merge is aborted because the database is closing")
+	ErrReadOnly               = errors.New("the database is opened in read only mode")
+	ErrRepairOutputNotEmpty   = errors.New("the repair output directory is not empty")
+	ErrIndexPointsToDeleted   = errors.New("the index entry points to a deleted record")
+	ErrIndexKeyMismatch       = errors.New("the index entry points to a record with a different key")
+	ErrCheckpointDirNotEmpty  = errors.New("the checkpoint directory is not empty")
+	ErrBackupDirNotEmpty      = errors.New("the backup directory is not empty")
+	ErrRestoreTargetNotEmpty  = errors.New("the restore target directory is not empty")
+	ErrBackupFileMissing      = errors.New("a file required by the backup manifest is missing")
+	ErrBackupCorrupted        = errors.New("the backup file does not match its manifest")
+	ErrInvalidBackupArchive   = errors.New("the backup archive contains an invalid entry")
+	ErrUnknownCompressor      = errors.New("the record is compressed by an unregistered compressor")
+	ErrWrongEncryptionKey     = errors.New("failed to decrypt the record, the encryption key is wrong")
+	ErrEncryptionKeyRequired  = errors.New("the record is encrypted but no encryption key is configured")
+	ErrSyncFailed             = errors.New("a previous sync failed, writes are rejected until the database is reopened")
 )
@@ -24,6 +24,9 @@ type IOManager interface {
 
 	// Size returns the size of the file.
 	Size() (int64, error)
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
 }
 
 // 初始化IOManager
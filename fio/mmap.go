@@ -1,6 +1,7 @@
 package fio
 
 import (
+	"errors"
 	"os"
 
 	"golang.org/x/exp/mmap"
@@ -44,6 +45,11 @@ func (mmap *MMap) Close() error {
 	return mmap.readerAt.Close()
 }
 
+// Truncate changes the size of the file.
+func (mmap *MMap) Truncate(size int64) error {
+	return errors.New("mmap io manager does not support truncate")
+}
+
 // Size returns the size of the file.
 func (mmap *MMap) Size() (int64, error) {
 	return int64(mmap.readerAt.Len()), nil
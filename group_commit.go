@@ -0,0 +1,121 @@
+package bitcask_go
+
+import "sync"
+
+// 组提交，并发的同步写入进入等待队列，由其中一个写入者（leader）持有db.mu依次执行队列中的全部写入，
+// 执行一次持久化之后再释放db.mu，避免写入者各自持久化
+// 持久化完成之前其他读取者无法获得db.mu，监听事件也在持久化之后才发送，与逐条持久化时一样只能读到已经持久化的数据
+// ART索引的迭代器分批读取实时的索引，创建之后仍然可能读到正在持久化的key，读取value时会等待持久化完成
+type groupSyncer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*syncWrite // 等待执行的写入
+	leading bool         // 是否有写入者正在执行一组写入
+}
+
+// 等待组提交的写入
+type syncWrite struct {
+	fn   func() error
+	err  error
+	done bool
+}
+
+func newGroupSyncer() *groupSyncer {
+	gs := &groupSyncer{}
+	gs.cond = sync.NewCond(&gs.mu)
+	return gs
+}
+
+// 持有db.mu执行写入，sync 为 true 时与其他并发的同步写入一起执行，持久化之后才返回
+// 只读模式下返回 ErrReadOnly，之前的组提交持久化失败时返回 ErrSyncFailed
+func (db *DB) writeWithSync(sync bool, fn func() error) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if !sync {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if db.syncFailed {
+			return ErrSyncFailed
+		}
+		return fn()
+	}
+
+	gs := db.syncer
+	w := &syncWrite{fn: fn}
+	gs.mu.Lock()
+	gs.queue = append(gs.queue, w)
+	// 等待其他leader执行完成，写入已经被执行时直接返回
+	for gs.leading && !w.done {
+		gs.cond.Wait()
+	}
+	if w.done {
+		gs.mu.Unlock()
+		return w.err
+	}
+
+	// 成为leader，执行队列中的全部写入
+	gs.leading = true
+	group := gs.queue
+	gs.queue = nil
+	gs.mu.Unlock()
+
+	db.commitGroup(group)
+
+	gs.mu.Lock()
+	for _, write := range group {
+		write.done = true
+	}
+	gs.leading = false
+	gs.cond.Broadcast()
+	gs.mu.Unlock()
+	return w.err
+}
+
+// 持有db.mu依次执行一组写入并持久化，持久化失败时所有写入都返回错误
+// 失败的写入已经更新了内存索引，无法撤销，之后的写入都返回 ErrSyncFailed，重新打开数据库后恢复
+func (db *DB) commitGroup(group []*syncWrite) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.syncFailed {
+		for _, write := range group {
+			write.err = ErrSyncFailed
+		}
+		return
+	}
+
+	before := db.appendCount
+	db.deferWatchEvents = true
+	for _, write := range group {
+		write.err = write.fn()
+	}
+	db.deferWatchEvents = false
+	events := db.pendingWatchEvents
+	db.pendingWatchEvents = nil
+
+	// 没有追加任何记录时不需要持久化
+	if db.appendCount == before {
+		return
+	}
+
+	// 活跃文件切换时旧文件已经持久化，只需要持久化当前的活跃文件，先持久化指针记录引用的blob文件
+	err := db.syncBlobFile()
+	if err == nil {
+		err = db.activeFile.Sync()
+	}
+	if err != nil {
+		db.syncFailed = true
+		for _, write := range group {
+			if write.err == nil {
+				write.err = err
+			}
+		}
+		return
+	}
+
+	for _, batch := range events {
+		db.notifyWatchers(batch)
+	}
+}
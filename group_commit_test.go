@@ -0,0 +1,146 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 记录已经持久化的数据大小，syncErr 不为空时模拟持久化失败
+type syncTrackingIO struct {
+	fio.IOManager
+	mu      sync.Mutex
+	written int64
+	synced  int64
+	syncErr error
+}
+
+func (s *syncTrackingIO) Write(b []byte) (int, error) {
+	n, err := s.IOManager.Write(b)
+	s.mu.Lock()
+	s.written += int64(n)
+	s.mu.Unlock()
+	return n, err
+}
+
+func (s *syncTrackingIO) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncErr != nil {
+		return s.syncErr
+	}
+	if err := s.IOManager.Sync(); err != nil {
+		return err
+	}
+	s.synced = s.written
+	return nil
+}
+
+func (s *syncTrackingIO) syncedSize() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.synced
+}
+
+// 替换活跃文件的IOManager，返回替换时活跃文件的大小
+func trackActiveFileSync(t *testing.T, db *DB) (*syncTrackingIO, int64) {
+	assert.Nil(t, db.Put([]byte("init"), []byte("value")))
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tracker := &syncTrackingIO{IOManager: db.activeFile.IoManager}
+	db.activeFile.IoManager = tracker
+	return tracker, db.activeFile.WriteOff
+}
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.WatchBufferSize = 1000
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	events := db.Watch(context.Background(), nil)
+	tracker, base := trackActiveFileSync(t, db)
+
+	// utils.RandomValue 不是并发安全的，提前生成
+	value := utils.RandomValue(64)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(w*100+i), value))
+				// 返回时记录已经持久化
+				db.mu.RLock()
+				pos := db.index.Get(utils.GetTestKey(w*100 + i))
+				db.mu.RUnlock()
+				assert.True(t, pos.Offset+int64(pos.Size) <= base+tracker.syncedSize())
+			}
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.Put(utils.GetTestKey(1000+w), value))
+			assert.Nil(t, wb.Delete(utils.GetTestKey(w*100)))
+			assert.Nil(t, wb.Commit())
+		}(w)
+	}
+	wg.Wait()
+
+	// 事件在持久化之后发送，"init" 的事件在替换IOManager之前发送
+	assert.Equal(t, base+tracker.syncedSize(), db.activeFile.WriteOff)
+	assert.Equal(t, 1+8*102, len(events))
+
+	// 没有追加记录的写入不需要等待持久化
+	assert.Nil(t, db.Delete([]byte("not-exist")))
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 801, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(1007))
+	assert.Nil(t, err)
+}
+
+func TestDB_GroupCommitSyncFailed(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-failed")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	events := db.Watch(context.Background(), nil)
+	tracker, _ := trackActiveFileSync(t, db)
+	<-events
+
+	// 持久化失败之后拒绝所有写入，不发送事件
+	syncErr := errors.New("sync failed")
+	tracker.syncErr = syncErr
+	assert.Equal(t, syncErr, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	tracker.syncErr = nil
+	assert.Equal(t, ErrSyncFailed, db.Put(utils.GetTestKey(2), utils.RandomValue(24)))
+	wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchNum: 10, SyncWrites: false})
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), utils.RandomValue(24)))
+	assert.Equal(t, ErrSyncFailed, wb.Commit())
+	assert.Equal(t, 0, len(events))
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重新打开之后恢复写入
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Put(utils.GetTestKey(2), utils.RandomValue(24)))
+}
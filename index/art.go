@@ -3,21 +3,18 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
-
-	goart "github.com/plar/go-adaptive-radix-tree"
 )
 
 type AdaptiveRadixTree struct {
-	tree goart.Tree
+	tree *artTree
 	lock *sync.RWMutex
 }
 
 // 初始化ART树索引
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goart.New(),
+		tree: newARTTree(),
 		lock: new(sync.RWMutex),
 	}
 }
@@ -25,42 +22,37 @@ func NewART() *AdaptiveRadixTree {
 // Put inserts a key-value pair into the index.
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
-	oldValue, _ := art.tree.Insert(key, pos)
-	art.lock.Unlock()
-	if oldValue == nil {
-		return nil
-	}
-	return oldValue.(*data.LogRecordPos)
+	defer art.lock.Unlock()
+	return art.tree.insert(key, pos)
 }
 
 // Get retrieves the position of a key from the index.
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	oldValue, found := art.tree.Search(key)
-	if !found {
-		return nil
-	}
-	return oldValue.(*data.LogRecordPos) // 转换类型
+	return art.tree.search(key)
 }
 
 // Delete removes a key-value pair from the index.
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	art.lock.RLock()
-	oldValue, deleted := art.tree.Delete(key)
-	art.lock.RUnlock()
-	if oldValue == nil {
-		return nil, false
-	}
-	return oldValue.(*data.LogRecordPos), deleted
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	return art.tree.delete(key)
 }
 
 // Size returns the number of key-value pairs in the index.
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
-	size := art.tree.Size()
-	art.lock.RUnlock()
-	return size
+	defer art.lock.RUnlock()
+	return art.tree.size
+}
+
+// Snapshot returns a read-only copy of the index at the current moment.
+// 基于写时复制生成快照，代价与索引大小无关
+func (art *AdaptiveRadixTree) Snapshot() Indexer {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	return &AdaptiveRadixTree{tree: art.tree.clone(), lock: new(sync.RWMutex)}
 }
 
 func (art *AdaptiveRadixTree) Close() error {
@@ -68,90 +60,98 @@ func (art *AdaptiveRadixTree) Close() error {
 }
 
 // Iterator returns an iterator over the index.
+// ART 的迭代器分批读取实时的索引，创建之后的写入在之后的批次中可能可见，
+// BTree 的迭代器则遍历创建时的快照
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	return newARTIterator(art, reverse)
 }
 
-// ART 索引迭代器
+// ART 索引迭代器，每次从树中取出一批索引项，占用的内存与索引大小无关
+// 每一批都沿着上一批最后一个key的路径从根节点向下查找，代价与索引大小无关
 type artIterator struct {
-	curIndex int     // 当前索引
-	reverse  bool    // 是否倒序
-	values   []*Item // key索引值
+	art     *AdaptiveRadixTree
+	reverse bool   // 是否倒序
+	items   []Item // 当前批次的索引项
+	index   int    // 当前索引项在批次中的位置
+	more    bool   // 当前批次之后是否还有索引项
+}
+
+func newARTIterator(art *AdaptiveRadixTree, reverse bool) *artIterator {
+	ai := &artIterator{
+		art:     art,
+		reverse: reverse,
+		items:   make([]Item, 0, iteratorBatchSize),
+	}
+	ai.Rewind()
+	return ai
 }
 
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
-	if reverse {
-		idx = tree.Size() - 1
-	}
-	values := make([]*Item, tree.Size())
-	saveValues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+// 从 pivot 开始取出一批索引项，pivot 为空时从头开始，inclusive 表示是否包含 pivot
+func (ai *artIterator) load(pivot []byte, inclusive bool) {
+	ai.art.lock.RLock()
+	defer ai.art.lock.RUnlock()
+
+	ai.items = ai.items[:0]
+	ai.index = 0
+	ai.more = false
+
+	saveItems := func(leaf *artLeaf) bool {
+		if !inclusive && bytes.Equal(leaf.key, pivot) {
+			return true
 		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
+		if len(ai.items) == iteratorBatchSize {
+			ai.more = true
+			return false
 		}
+		ai.items = append(ai.items, Item{key: leaf.key, pos: leaf.pos})
 		return true
 	}
 
-	tree.ForEach(saveValues)
-
-	return &artIterator{
-		curIndex: 0,
-		reverse:  reverse,
-		values:   values,
+	root := ai.art.tree.root
+	switch {
+	case pivot == nil:
+		root.walk(ai.reverse, saveItems)
+	case ai.reverse:
+		root.descend(pivot, saveItems)
+	default:
+		root.ascend(pivot, saveItems)
 	}
 }
 
 // 回到起始位置
 func (ai *artIterator) Rewind() {
-	ai.curIndex = 0
+	ai.load(nil, true)
 }
 
-// 从这个key开始遍历
+// 从这个key开始遍历，倒序时从小于等于key的第一个key开始
 func (ai *artIterator) Seek(key []byte) {
-
-	// 二分查找有序数组
-	if ai.reverse {
-		ai.curIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) <= 0
-		})
-	} else {
-		ai.curIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) >= 0
-		})
-	}
-
+	ai.load(key, true)
 }
 
 // 跳转到下一个key
 func (ai *artIterator) Next() {
-	ai.curIndex += 1
+	ai.index++
+	if ai.index == len(ai.items) && ai.more {
+		ai.load(ai.items[len(ai.items)-1].key, false)
+	}
 }
 
 // 当前位置是否有效
 func (ai *artIterator) Valid() bool {
-	return ai.curIndex < len(ai.values)
+	return ai.index < len(ai.items)
 }
 
 // 当前key
 func (ai *artIterator) Key() []byte {
-	return ai.values[ai.curIndex].key
+	return ai.items[ai.index].key
 }
 
 // 当前value
 func (ai *artIterator) Value() *data.LogRecordPos {
-	return ai.values[ai.curIndex].pos
+	return ai.items[ai.index].pos
 }
 
 // 关闭迭代器
 func (ai *artIterator) Close() {
-	ai.values = nil
+	ai.items = nil
 }
@@ -2,6 +2,8 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -63,3 +65,136 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 	}
 	it.Close()
 }
+
+func TestAdaptiveRadixTree_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	art.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 2})
+
+	snap := art.Snapshot()
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	art.Delete([]byte("b"))
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(1), snap.Get([]byte("a")).Offset)
+	assert.Equal(t, int64(2), snap.Get([]byte("b")).Offset)
+
+	// 快照的写入同样不影响原来的索引
+	snap.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 4})
+	snap.Delete([]byte("a"))
+	assert.Equal(t, 1, art.Size())
+	assert.Nil(t, art.Get([]byte("c")))
+	assert.Equal(t, int64(3), art.Get([]byte("a")).Offset)
+}
+
+// 检查节点结构：子节点有序，数量与保存方式一致，除根节点之外没有索引项的节点至少有两个子节点
+func checkARTNode(t *testing.T, n *artNode, isRoot bool) int {
+	if n.child256 != nil {
+		assert.Nil(t, n.keys)
+		assert.True(t, n.numChildren > artSmallNodeMax/2)
+	} else {
+		assert.Equal(t, len(n.keys), n.numChildren)
+		assert.Equal(t, len(n.keys), len(n.children))
+		assert.True(t, len(n.keys) <= artSmallNodeMax)
+	}
+	if !isRoot && n.leaf == nil {
+		assert.True(t, n.numChildren >= 2)
+	}
+
+	size := 0
+	if n.leaf != nil {
+		size++
+	}
+	count := 0
+	prev := -1
+	n.eachChild(false, func(c byte, child *artNode) bool {
+		assert.True(t, int(c) > prev)
+		prev = int(c)
+		count++
+		size += checkARTNode(t, child, false)
+		return true
+	})
+	assert.Equal(t, n.numChildren, count)
+	return size
+}
+
+func TestAdaptiveRadixTree_CompareBTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// key之间互为前缀，同一个节点的子节点数量在有序保存和256个槽位之间反复切换
+	randomKey := func() []byte {
+		switch rnd.Intn(4) {
+		case 0:
+			return []byte{byte(rnd.Intn(256))}
+		case 1:
+			return []byte(fmt.Sprintf("key-%d", rnd.Intn(500)))
+		case 2:
+			return append([]byte("key-1"), byte(rnd.Intn(256)))
+		default:
+			key := make([]byte, rnd.Intn(4))
+			for i := range key {
+				key[i] = byte(rnd.Intn(3))
+			}
+			return key
+		}
+	}
+
+	art := NewART()
+	bt := NewBTree()
+	collect := func(iter Iterator) [][]byte {
+		result := [][]byte{}
+		for ; iter.Valid(); iter.Next() {
+			result = append(result, iter.Key())
+		}
+		iter.Close()
+		return result
+	}
+	var artSnaps, btSnaps []Indexer
+	for round := 0; round < 20; round++ {
+		// 每一轮开始时创建快照，之后的写入不影响快照
+		artSnaps = append(artSnaps, art.Snapshot())
+		btSnaps = append(btSnaps, bt.Snapshot())
+
+		// 交替进行以写入为主和以删除为主的操作
+		deleteRatio, ops := 2, 2000
+		if round%2 == 1 {
+			deleteRatio, ops = 10, 8000
+		}
+		for i := 0; i < ops; i++ {
+			key := randomKey()
+			if rnd.Intn(10) < deleteRatio {
+				artPos, artDeleted := art.Delete(key)
+				btPos, btDeleted := bt.Delete(key)
+				assert.Equal(t, btDeleted, artDeleted)
+				assert.Equal(t, btPos, artPos)
+				continue
+			}
+			pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i)}
+			assert.Equal(t, bt.Put(key, pos), art.Put(key, pos))
+		}
+
+		assert.Equal(t, bt.Size(), art.Size())
+		assert.Equal(t, art.Size(), checkARTNode(t, art.tree.root, true))
+		for i := 0; i < 50; i++ {
+			key := randomKey()
+			assert.Equal(t, bt.Get(key), art.Get(key))
+		}
+
+		for i := range artSnaps {
+			snap := artSnaps[i].(*AdaptiveRadixTree)
+			assert.Equal(t, btSnaps[i].Size(), snap.Size())
+			assert.Equal(t, snap.Size(), checkARTNode(t, snap.tree.root, true))
+			assert.Equal(t, collect(btSnaps[i].Iterator(false)), collect(snap.Iterator(false)))
+		}
+
+		for _, reverse := range []bool{false, true} {
+			assert.Equal(t, collect(bt.Iterator(reverse)), collect(art.Iterator(reverse)))
+			for i := 0; i < 20; i++ {
+				pivot := randomKey()
+				btIter, artIter := bt.Iterator(reverse), art.Iterator(reverse)
+				btIter.Seek(pivot)
+				artIter.Seek(pivot)
+				assert.Equal(t, collect(btIter), collect(artIter))
+			}
+		}
+	}
+}
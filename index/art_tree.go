@@ -0,0 +1,401 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+)
+
+// 子节点数量超过这个值时改为按字节保存全部256个子节点
+const artSmallNodeMax = 48
+
+// 自适应基数树，压缩只有一个子节点的路径，支持从任意key开始正序和倒序遍历
+// 支持写时复制，clone 之后两棵树共享全部节点，写入时复制路径上被共享的节点
+type artTree struct {
+	root *artNode
+	size int
+	cow  *artCow // 节点的标记与树相同时可以直接修改
+}
+
+// 写时复制的标记，需要非零大小，保证每次分配的地址不同
+type artCow struct{ _ byte }
+
+// 树中的索引项
+type artLeaf struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// 树的节点，从根节点到这个节点的路径为父节点的路径、父节点指向这个节点的字节和 prefix
+// 子节点较少时按字节有序保存在 keys 和 children 中，较多时保存在 child256 中
+type artNode struct {
+	prefix      []byte         // 压缩的路径
+	leaf        *artLeaf       // key 恰好在这个节点结束时的索引项
+	keys        []byte         // 有序的子节点字节
+	children    []*artNode     // 与 keys 对应的子节点
+	child256    *[256]*artNode // 按字节保存的子节点
+	numChildren int            // 子节点数量
+	cow         *artCow        // 创建或复制这个节点的树的标记
+}
+
+func newARTTree() *artTree {
+	cow := new(artCow)
+	return &artTree{root: &artNode{cow: cow}, cow: cow}
+}
+
+// 复制一棵共享全部节点的树，之后两棵树的写入都会先复制被共享的节点
+func (t *artTree) clone() *artTree {
+	t.cow = new(artCow)
+	return &artTree{root: t.root, size: t.size, cow: new(artCow)}
+}
+
+// 返回可以直接修改的节点，节点被其他树共享时返回它的副本
+func (t *artTree) writable(n *artNode) *artNode {
+	if n.cow == t.cow {
+		return n
+	}
+	c := *n
+	c.cow = t.cow
+	c.keys = append([]byte(nil), n.keys...)
+	c.children = append([]*artNode(nil), n.children...)
+	if n.child256 != nil {
+		child256 := *n.child256
+		c.child256 = &child256
+	}
+	return &c
+}
+
+// 返回可以直接修改的子节点，需要复制时替换父节点中的指针，n 需要是可以直接修改的节点
+func (t *artTree) writableChild(n *artNode, c byte) *artNode {
+	child := n.child(c)
+	if child == nil || child.cow == t.cow {
+		return child
+	}
+	child = t.writable(child)
+	n.setChild(c, child)
+	return child
+}
+
+// 查找key对应的索引项
+func (t *artTree) search(key []byte) *data.LogRecordPos {
+	n := t.root
+	depth := 0
+	for n != nil {
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.leaf == nil {
+				return nil
+			}
+			return n.leaf.pos
+		}
+		n = n.child(key[depth])
+		depth++
+	}
+	return nil
+}
+
+// 插入索引项，返回旧的位置
+func (t *artTree) insert(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	t.root = t.writable(t.root)
+	n := t.root
+	depth := 0
+	for {
+		// 压缩的路径与key不同时拆分节点
+		common := commonPrefixLen(n.prefix, key[depth:])
+		if common < len(n.prefix) {
+			edge := n.prefix[common]
+			child := *n
+			child.prefix = n.prefix[common+1:]
+			*n = artNode{prefix: n.prefix[:common], cow: t.cow}
+			n.setChild(edge, &child)
+		}
+
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.leaf == nil {
+				n.leaf = &artLeaf{key: key, pos: pos}
+				t.size++
+				return nil
+			}
+			oldPos := n.leaf.pos
+			n.leaf = &artLeaf{key: key, pos: pos}
+			return oldPos
+		}
+
+		next := t.writableChild(n, key[depth])
+		if next == nil {
+			n.setChild(key[depth], &artNode{prefix: key[depth+1:], leaf: &artLeaf{key: key, pos: pos}, cow: t.cow})
+			t.size++
+			return nil
+		}
+		n = next
+		depth++
+	}
+}
+
+// 删除索引项，返回旧的位置
+func (t *artTree) delete(key []byte) (*data.LogRecordPos, bool) {
+	type edge struct {
+		node *artNode
+		c    byte
+	}
+	var path []edge
+	t.root = t.writable(t.root)
+	n := t.root
+	depth := 0
+	for {
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil, false
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			break
+		}
+		next := t.writableChild(n, key[depth])
+		if next == nil {
+			return nil, false
+		}
+		path = append(path, edge{node: n, c: key[depth]})
+		n = next
+		depth++
+	}
+	if n.leaf == nil {
+		return nil, false
+	}
+	oldPos := n.leaf.pos
+	n.leaf = nil
+	t.size--
+
+	// 去掉空的节点，只剩一个子节点的节点与子节点合并
+	for len(path) > 0 && n.leaf == nil && n.numChildren == 0 {
+		parent := path[len(path)-1]
+		parent.node.removeChild(parent.c)
+		path = path[:len(path)-1]
+		n = parent.node
+	}
+	switch {
+	case n.leaf == nil && n.numChildren == 1:
+		t.mergeChild(n)
+	case n.leaf == nil && n.numChildren == 0:
+		// 只有根节点可能为空，合并之后保留的路径需要去掉，否则插入时会拆分出空的节点
+		*n = artNode{cow: t.cow}
+	}
+	return oldPos, true
+}
+
+// 查找字节对应的子节点
+func (n *artNode) child(c byte) *artNode {
+	if n.child256 != nil {
+		return n.child256[c]
+	}
+	for i, k := range n.keys {
+		if k == c {
+			return n.children[i]
+		}
+		if k > c {
+			break
+		}
+	}
+	return nil
+}
+
+// 添加或者替换子节点
+func (n *artNode) setChild(c byte, child *artNode) {
+	if n.child256 != nil {
+		if n.child256[c] == nil {
+			n.numChildren++
+		}
+		n.child256[c] = child
+		return
+	}
+
+	i := 0
+	for i < len(n.keys) && n.keys[i] < c {
+		i++
+	}
+	if i < len(n.keys) && n.keys[i] == c {
+		n.children[i] = child
+		return
+	}
+	n.numChildren++
+	if len(n.keys) == artSmallNodeMax {
+		n.child256 = new([256]*artNode)
+		for j, k := range n.keys {
+			n.child256[k] = n.children[j]
+		}
+		n.child256[c] = child
+		n.keys, n.children = nil, nil
+		return
+	}
+	n.keys = append(n.keys, 0)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = c
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+// 删除子节点，子节点变少时改回有序保存
+func (n *artNode) removeChild(c byte) {
+	if n.child256 != nil {
+		if n.child256[c] == nil {
+			return
+		}
+		n.child256[c] = nil
+		n.numChildren--
+		if n.numChildren <= artSmallNodeMax/2 {
+			for k, child := range n.child256 {
+				if child != nil {
+					n.keys = append(n.keys, byte(k))
+					n.children = append(n.children, child)
+				}
+			}
+			n.child256 = nil
+		}
+		return
+	}
+
+	for i, k := range n.keys {
+		if k == c {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			copy(n.children[i:], n.children[i+1:])
+			n.children[len(n.children)-1] = nil
+			n.children = n.children[:len(n.children)-1]
+			n.numChildren--
+			return
+		}
+	}
+}
+
+// 节点与唯一的子节点合并，子节点被共享时先复制，避免合并之后的节点与其他树共享子节点列表
+func (t *artTree) mergeChild(n *artNode) {
+	var c byte
+	var child *artNode
+	n.eachChild(false, func(k byte, node *artNode) bool {
+		c, child = k, node
+		return false
+	})
+	child = t.writable(child)
+	prefix := make([]byte, 0, len(n.prefix)+1+len(child.prefix))
+	prefix = append(prefix, n.prefix...)
+	prefix = append(prefix, c)
+	prefix = append(prefix, child.prefix...)
+	*n = *child
+	n.prefix = prefix
+}
+
+// 按字节顺序遍历子节点，fn 返回 false 时停止并返回 false
+func (n *artNode) eachChild(reverse bool, fn func(c byte, child *artNode) bool) bool {
+	if n.child256 != nil {
+		for i := 0; i < len(n.child256); i++ {
+			c := i
+			if reverse {
+				c = len(n.child256) - 1 - i
+			}
+			if child := n.child256[c]; child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := range n.keys {
+		j := i
+		if reverse {
+			j = len(n.keys) - 1 - i
+		}
+		if !fn(n.keys[j], n.children[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 按顺序遍历子树中的全部索引项，key 较短的索引项小于子节点中的索引项
+func (n *artNode) walk(reverse bool, fn func(leaf *artLeaf) bool) bool {
+	if !reverse && n.leaf != nil && !fn(n.leaf) {
+		return false
+	}
+	if !n.eachChild(reverse, func(_ byte, child *artNode) bool {
+		return child.walk(reverse, fn)
+	}) {
+		return false
+	}
+	if reverse && n.leaf != nil {
+		return fn(n.leaf)
+	}
+	return true
+}
+
+// 正序遍历子树中大于等于 pivot 的索引项，pivot 为去掉父节点路径之后剩余的部分
+// 只沿着 pivot 的路径向下查找，跳过小于 pivot 的子树
+func (n *artNode) ascend(pivot []byte, fn func(leaf *artLeaf) bool) bool {
+	m := min(len(n.prefix), len(pivot))
+	switch cmp := bytes.Compare(n.prefix[:m], pivot[:m]); {
+	case cmp < 0:
+		return true
+	case cmp > 0 || len(pivot) <= len(n.prefix):
+		return n.walk(false, fn)
+	}
+
+	// 这个节点的索引项小于 pivot
+	rest := pivot[len(n.prefix):]
+	return n.eachChild(false, func(c byte, child *artNode) bool {
+		switch {
+		case c < rest[0]:
+			return true
+		case c == rest[0]:
+			return child.ascend(rest[1:], fn)
+		default:
+			return child.walk(false, fn)
+		}
+	})
+}
+
+// 倒序遍历子树中小于等于 pivot 的索引项，pivot 为去掉父节点路径之后剩余的部分
+// 只沿着 pivot 的路径向下查找，跳过大于 pivot 的子树
+func (n *artNode) descend(pivot []byte, fn func(leaf *artLeaf) bool) bool {
+	m := min(len(n.prefix), len(pivot))
+	switch cmp := bytes.Compare(n.prefix[:m], pivot[:m]); {
+	case cmp > 0 || cmp == 0 && len(pivot) < len(n.prefix):
+		return true
+	case cmp < 0:
+		return n.walk(true, fn)
+	case len(pivot) == len(n.prefix):
+		// 子节点中的索引项都大于 pivot
+		if n.leaf != nil {
+			return fn(n.leaf)
+		}
+		return true
+	}
+
+	rest := pivot[len(n.prefix):]
+	if !n.eachChild(true, func(c byte, child *artNode) bool {
+		switch {
+		case c > rest[0]:
+			return true
+		case c == rest[0]:
+			return child.descend(rest[1:], fn)
+		default:
+			return child.walk(true, fn)
+		}
+	}) {
+		return false
+	}
+	if n.leaf != nil {
+		return fn(n.leaf)
+	}
+	return true
+}
+
+// 两个字节数组相同前缀的长度
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
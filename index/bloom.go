@@ -0,0 +1,191 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// 布隆过滤器的最小容量
+const minBloomCapacity = 4096
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter")
+
+// 布隆过滤器，判断key一定不存在或者可能存在
+type BloomFilter struct {
+	bits     []uint64
+	k        uint32  // 哈希函数数量
+	capacity uint64  // 误判率不超过 fpRate 时可以容纳的key数量
+	count    uint64  // 已经加入的key数量
+	fpRate   float64 // 误判率
+}
+
+// 创建可以容纳 capacity 个key、误判率为 fpRate 的布隆过滤器
+func NewBloomFilter(capacity uint64, fpRate float64) *BloomFilter {
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return &BloomFilter{
+		bits:     make([]uint64, (m+63)/64),
+		k:        k,
+		capacity: capacity,
+		fpRate:   fpRate,
+	}
+}
+
+// 使用两个哈希值组合出 k 个哈希函数
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+func (bf *BloomFilter) Add(key []byte) {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(bf.bits)) * 64
+	for i := uint32(0); i < bf.k; i++ {
+		bit := (uint64(h1) + uint64(i)*uint64(h2)) % m
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+	bf.count++
+}
+
+// key是否可能存在，返回 false 时key一定不存在
+func (bf *BloomFilter) MayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(bf.bits)) * 64
+	for i := uint32(0); i < bf.k; i++ {
+		bit := (uint64(h1) + uint64(i)*uint64(h2)) % m
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 误判率
+func (bf *BloomFilter) FalsePositiveRate() float64 {
+	return bf.fpRate
+}
+
+// 编码格式为 哈希函数数量 + 容量 + key数量 + 误判率 + 位数组
+func (bf *BloomFilter) Encode() []byte {
+	buf := make([]byte, 28+len(bf.bits)*8)
+	binary.LittleEndian.PutUint32(buf[0:], bf.k)
+	binary.LittleEndian.PutUint64(buf[4:], bf.capacity)
+	binary.LittleEndian.PutUint64(buf[12:], bf.count)
+	binary.LittleEndian.PutUint64(buf[20:], math.Float64bits(bf.fpRate))
+	for i, word := range bf.bits {
+		binary.LittleEndian.PutUint64(buf[28+i*8:], word)
+	}
+	return buf
+}
+
+func DecodeBloomFilter(buf []byte) (*BloomFilter, error) {
+	if len(buf) < 28 || (len(buf)-28)%8 != 0 || len(buf) == 28 {
+		return nil, ErrInvalidBloomFilter
+	}
+	bf := &BloomFilter{
+		k:        binary.LittleEndian.Uint32(buf[0:]),
+		capacity: binary.LittleEndian.Uint64(buf[4:]),
+		count:    binary.LittleEndian.Uint64(buf[12:]),
+		fpRate:   math.Float64frombits(binary.LittleEndian.Uint64(buf[20:])),
+		bits:     make([]uint64, (len(buf)-28)/8),
+	}
+	if bf.k == 0 {
+		return nil, ErrInvalidBloomFilter
+	}
+	for i := range bf.bits {
+		bf.bits[i] = binary.LittleEndian.Uint64(buf[28+i*8:])
+	}
+	return bf, nil
+}
+
+// 使用布隆过滤器的索引，查找一定不存在的key时不访问内部的索引
+// 删除的key仍然保留在过滤器中，加入的key超过容量时根据内部索引重建更大的过滤器
+type BloomIndexer struct {
+	Indexer
+	mu      sync.RWMutex
+	filter  *BloomFilter
+	avoided uint64 // 过滤器判断key不存在，没有访问内部索引的次数
+}
+
+// filter 需要包含 inner 中所有的key，为空时根据 inner 创建
+func NewBloomIndexer(inner Indexer, filter *BloomFilter, fpRate float64) *BloomIndexer {
+	bi := &BloomIndexer{Indexer: inner, filter: filter}
+	if filter == nil {
+		bi.filter = NewBloomFilter(0, fpRate)
+		bi.rebuild()
+	}
+	return bi
+}
+
+func (bi *BloomIndexer) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldPos := bi.Indexer.Put(key, pos)
+	if oldPos != nil {
+		return oldPos
+	}
+
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	bi.filter.Add(key)
+	if bi.filter.count > bi.filter.capacity {
+		bi.rebuild()
+	}
+	return nil
+}
+
+func (bi *BloomIndexer) Get(key []byte) *data.LogRecordPos {
+	bi.mu.RLock()
+	mayContain := bi.filter.MayContain(key)
+	bi.mu.RUnlock()
+	if !mayContain {
+		atomic.AddUint64(&bi.avoided, 1)
+		return nil
+	}
+	return bi.Indexer.Get(key)
+}
+
+func (bi *BloomIndexer) Delete(key []byte) (*data.LogRecordPos, bool) {
+	bi.mu.RLock()
+	mayContain := bi.filter.MayContain(key)
+	bi.mu.RUnlock()
+	if !mayContain {
+		atomic.AddUint64(&bi.avoided, 1)
+		return nil, false
+	}
+	return bi.Indexer.Delete(key)
+}
+
+// 根据内部索引中的key重建过滤器，容量为key数量的两倍，调用方需要持有 bi.mu
+func (bi *BloomIndexer) rebuild() {
+	filter := NewBloomFilter(uint64(bi.Indexer.Size())*2, bi.filter.fpRate)
+	iter := bi.Indexer.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		filter.Add(iter.Key())
+	}
+	bi.filter = filter
+}
+
+// 编码当前的过滤器
+func (bi *BloomIndexer) EncodeFilter() []byte {
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	return bi.filter.Encode()
+}
+
+// 过滤器判断key不存在，没有访问内部索引的次数
+func (bi *BloomIndexer) AvoidedProbes() uint64 {
+	return atomic.LoadUint64(&bi.avoided)
+}
@@ -0,0 +1,59 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	bf := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		bf.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	for i := 0; i < 10000; i++ {
+		assert.True(t, bf.MayContain([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if bf.MayContain([]byte(fmt.Sprintf("missing-%d", i))) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 300)
+
+	decoded, err := DecodeBloomFilter(bf.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, bf, decoded)
+	_, err = DecodeBloomFilter(bf.Encode()[:27])
+	assert.Equal(t, ErrInvalidBloomFilter, err)
+}
+
+func TestBloomIndexer(t *testing.T) {
+	inner := NewBTree()
+	inner.Put([]byte("existing"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	bi := NewBloomIndexer(inner, nil, 0.01)
+	assert.NotNil(t, bi.Get([]byte("existing")))
+
+	// 超过容量之后重建过滤器
+	for i := 0; i < 3*minBloomCapacity; i++ {
+		assert.Nil(t, bi.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	assert.True(t, bi.filter.capacity >= 3*minBloomCapacity)
+	for i := 0; i < 3*minBloomCapacity; i++ {
+		assert.NotNil(t, bi.Get([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, bi.Get([]byte(fmt.Sprintf("missing-%d", i))))
+	}
+	assert.True(t, bi.AvoidedProbes() > 900)
+
+	_, ok := bi.Delete([]byte("key-1"))
+	assert.True(t, ok)
+	assert.Nil(t, bi.Get([]byte("key-1")))
+	assert.Equal(t, 3*minBloomCapacity, bi.Size())
+}
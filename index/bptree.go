@@ -2,12 +2,13 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
 	"path/filepath"
 
 	"go.etcd.io/bbolt"
 )
 
-const bptreeIndexFileName = "bptree-index"
+const BPTreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
@@ -19,7 +20,7 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
 	// 与其他索引不同，b+树索引储存在磁盘，不是内存
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -94,6 +95,17 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
+// 磁盘上的b+树在读事务结束后无法保留视图，长时间持有读事务又会阻塞写入时的扩容，不支持快照
+func (bpt *BPlusTree) Snapshot() Indexer {
+	return nil
+}
+
+// 开启只读事务，提交之前事务中的视图不变，可以在不阻塞写入的情况下拷贝一致的索引文件
+// 只读事务结束之前写入无法扩大文件的内存映射，调用方需要尽快 Rollback
+func (bpt *BPlusTree) BeginRead() (*bbolt.Tx, error) {
+	return bpt.tree.Begin(false)
+}
+
 func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
@@ -134,9 +146,17 @@ func (bpi *bptreeIterator) Rewind() {
 	}
 }
 
-// 从这个key开始遍历
+// 从这个key开始遍历，倒序时从小于等于key的第一个key开始
 func (bpi *bptreeIterator) Seek(key []byte) {
 	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	if !bpi.reverse {
+		return
+	}
+	if bpi.currKey == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	} else if bytes.Compare(bpi.currKey, key) > 0 {
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	}
 }
 
 // 跳转到下一个key
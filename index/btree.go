@@ -3,7 +3,6 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -54,91 +53,113 @@ func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+// 迭代器遍历创建时的写时复制快照，之后的写入对迭代器不可见
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBtreeIterator(bt.tree, reverse)
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBtreeIterator(tree, reverse)
+}
+
+// 基于写时复制生成快照，代价与索引大小无关
+func (bt *BTree) Snapshot() Indexer {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{tree: bt.tree.Clone(), lock: new(sync.RWMutex)}
 }
 
 func (bt *BTree) Close() error {
 	return nil
 }
 
-// BTree 索引迭代器
+// BTree 索引迭代器，每次从树中取出一批索引项，占用的内存与索引大小无关
 type btreeIterator struct {
-	curIndex int     // 当前索引
-	reverse  bool    // 是否倒序
-	values   []*Item // key索引值
+	tree    *btree.BTree // 创建迭代器时的快照
+	reverse bool         // 是否倒序
+	items   []*Item      // 当前批次的索引项
+	index   int          // 当前索引项在批次中的位置
+	more    bool         // 当前批次之后是否还有索引项
 }
 
 func newBtreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
+		items:   make([]*Item, 0, iteratorBatchSize),
 	}
-
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
+	bti.Rewind()
+	return bti
+}
+
+// 从 pivot 开始取出一批索引项，pivot 为空时从头开始，inclusive 表示是否包含 pivot
+func (bti *btreeIterator) load(pivot []byte, inclusive bool) {
+	bti.items = bti.items[:0]
+	bti.index = 0
+	bti.more = false
+
+	saveItems := func(it btree.Item) bool {
+		item := it.(*Item)
+		if !inclusive && bytes.Equal(item.key, pivot) {
+			return true
+		}
+		if len(bti.items) == iteratorBatchSize {
+			bti.more = true
+			return false
+		}
+		bti.items = append(bti.items, item)
+		return true
 	}
 
-	return &btreeIterator{
-		curIndex: 0,
-		reverse:  reverse,
-		values:   values,
+	switch {
+	case pivot == nil && bti.reverse:
+		bti.tree.Descend(saveItems)
+	case pivot == nil:
+		bti.tree.Ascend(saveItems)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(&Item{key: pivot}, saveItems)
+	default:
+		bti.tree.AscendGreaterOrEqual(&Item{key: pivot}, saveItems)
 	}
 }
 
 // 回到起始位置
 func (bti *btreeIterator) Rewind() {
-	bti.curIndex = 0
+	bti.load(nil, true)
 }
 
-// 从这个key开始遍历
+// 从这个key开始遍历，倒序时从小于等于key的第一个key开始
 func (bti *btreeIterator) Seek(key []byte) {
-
-	// 二分查找有序数组
-	if bti.reverse {
-		bti.curIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.curIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
-
+	bti.load(key, true)
 }
 
 // 跳转到下一个key
 func (bti *btreeIterator) Next() {
-	bti.curIndex += 1
+	bti.index++
+	if bti.index == len(bti.items) && bti.more {
+		bti.load(bti.items[len(bti.items)-1].key, false)
+	}
 }
 
 // 当前位置是否有效
 func (bti *btreeIterator) Valid() bool {
-	return bti.curIndex < len(bti.values)
+	return bti.index < len(bti.items)
 }
 
 // 当前key
 func (bti *btreeIterator) Key() []byte {
-	return bti.values[bti.curIndex].key
+	return bti.items[bti.index].key
 }
 
 // 当前value
 func (bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.curIndex].pos
+	return bti.items[bti.index].pos
 }
 
 // 关闭迭代器
 func (bti *btreeIterator) Close() {
-	bti.values = nil
+	bti.tree = nil
+	bti.items = nil
 }
@@ -87,3 +87,20 @@ func TestBTree_Delete(t *testing.T) {
 // 		t.Log(string(iter6.Key()))
 // 	}
 // }
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 2})
+
+	snap := bt.Snapshot()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	bt.Delete([]byte("b"))
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 4})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(1), snap.Get([]byte("a")).Offset)
+	assert.Equal(t, int64(2), snap.Get([]byte("b")).Offset)
+	assert.Nil(t, snap.Get([]byte("c")))
+	assert.Equal(t, int64(3), bt.Get([]byte("a")).Offset)
+}
@@ -21,8 +21,13 @@ type Indexer interface {
 	Size() int
 
 	// Iterator returns an iterator over the index.
+	// BTree 和 B+树的迭代器遍历创建时的视图，ART 的迭代器分批读取实时的索引，创建之后的写入可能可见
 	Iterator(reverse bool) Iterator
 
+	// Snapshot returns a read-only copy of the index at the current moment,
+	// or nil if the index does not support snapshots.
+	Snapshot() Indexer
+
 	// Close closes the index and releases any resources.
 	Close() error
 }
@@ -57,6 +62,9 @@ func (ai *Item) Less(bi btree.Item) bool {
 	return bytes.Compare(ai.key, bi.(*Item).key) == -1
 }
 
+// 迭代器每次从索引中取出的索引项数量
+const iteratorBatchSize = 1024
+
 // 通用索引迭代器
 type Iterator interface {
 	Rewind()                   // 回到起始位置
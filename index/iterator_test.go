@@ -0,0 +1,120 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterator_Semantics(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-iterator")
+	defer os.RemoveAll(dir)
+
+	indexers := map[string]Indexer{
+		"btree":  NewBTree(),
+		"art":    NewART(),
+		"bptree": NewBPlusTree(dir, false),
+	}
+	for name, idx := range indexers {
+		t.Run(name, func(t *testing.T) {
+			defer idx.Close()
+
+			// 空索引
+			iter := idx.Iterator(false)
+			assert.False(t, iter.Valid())
+			iter.Close()
+
+			// 超过一批的索引项，key之间留有空隙
+			var keys [][]byte
+			for i := 0; i < 3*iteratorBatchSize+10; i++ {
+				key := []byte(fmt.Sprintf("key-%06d", i*2))
+				keys = append(keys, key)
+				idx.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			}
+			sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+			collect := func(iter Iterator) [][]byte {
+				var result [][]byte
+				for ; iter.Valid(); iter.Next() {
+					result = append(result, iter.Key())
+				}
+				return result
+			}
+			reversed := func(keys [][]byte) [][]byte {
+				result := make([][]byte, len(keys))
+				for i, key := range keys {
+					result[len(keys)-1-i] = key
+				}
+				return result
+			}
+
+			iter = idx.Iterator(false)
+			assert.Equal(t, keys, collect(iter))
+			iter.Seek([]byte("key-001000"))
+			assert.Equal(t, keys[500:], collect(iter))
+			iter.Seek([]byte("key-001001"))
+			assert.Equal(t, keys[501:], collect(iter))
+			iter.Seek([]byte("zzz"))
+			assert.False(t, iter.Valid())
+			iter.Rewind()
+			assert.Equal(t, keys, collect(iter))
+			iter.Close()
+
+			iter = idx.Iterator(true)
+			assert.Equal(t, reversed(keys), collect(iter))
+			iter.Seek([]byte("key-001000"))
+			assert.Equal(t, reversed(keys[:501]), collect(iter))
+			iter.Seek([]byte("key-001001"))
+			assert.Equal(t, reversed(keys[:501]), collect(iter))
+			iter.Seek([]byte("a"))
+			assert.False(t, iter.Valid())
+			iter.Seek([]byte("zzz"))
+			assert.Equal(t, reversed(keys), collect(iter))
+			iter.Close()
+		})
+	}
+}
+
+func TestIterator_ConcurrentWrites(t *testing.T) {
+	// BTree 迭代器遍历创建时的快照
+	bt := NewBTree()
+	for i := 0; i < 2*iteratorBatchSize; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%06d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter := bt.Iterator(false)
+	bt.Put([]byte("key-999999"), &data.LogRecordPos{Fid: 1})
+	bt.Delete([]byte("key-000000"))
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 2*iteratorBatchSize, count)
+	iter.Close()
+
+	// ART 迭代器在树被修改之后继续遍历，不重复也不遗漏没有修改的key
+	art := NewART()
+	for i := 0; i < 2*iteratorBatchSize; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%06d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter = art.Iterator(false)
+	var prev []byte
+	count = 0
+	for ; iter.Valid(); iter.Next() {
+		if prev != nil {
+			assert.True(t, bytes.Compare(prev, iter.Key()) < 0)
+		}
+		prev = iter.Key()
+		count++
+		if count == 10 {
+			art.Put([]byte("key-000000-new"), &data.LogRecordPos{Fid: 1})
+			art.Put([]byte("key-999999"), &data.LogRecordPos{Fid: 1})
+		}
+	}
+	assert.Equal(t, 2*iteratorBatchSize+1, count)
+	iter.Close()
+}
@@ -0,0 +1,391 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 索引快照中第一条和最后一条记录的key
+const indexSnapshotKey = "index.snapshot"
+
+var errInvalidIndexSnapshot = errors.New("invalid index snapshot")
+
+// 索引快照，保存内存索引的内容以及它覆盖的日志位置，启动时只需要回放该位置之后的日志
+// 文件格式与hint文件相同，第一条记录保存覆盖的位置等信息，之后每个key一条记录，最后一条记录标记快照完整
+// 操作数链保存为 LogRecordMerge 类型的记录，value为链中每条记录的位置和引用的blob记录位置
+type indexSnapshot struct {
+	fid            uint32 // 快照覆盖到的数据文件id
+	offset         int64  // 快照覆盖到的数据文件偏移
+	nonMergeFileId uint32 // 快照时最近一次merge的非merge文件id，merge生效之后快照失效
+	seqNo          uint64
+	reclaimSize    int64
+	index          index.Indexer
+	operands       map[operandKey][]*data.LogRecordPos
+	blobRefs       map[operandKey]*data.LogRecordPos
+}
+
+// 索引快照中的一个key
+type indexSnapshotEntry struct {
+	key   []byte
+	chain []*data.LogRecordPos // 索引位置，多于一个时为操作数链
+	blobs []*data.LogRecordPos // 链中每条记录引用的blob记录位置，没有引用时为空
+}
+
+// 持久化快照覆盖的日志，并复制保存快照需要的内存状态，调用方需要持有db.mu
+func (db *DB) captureIndexSnapshot() (*indexSnapshot, error) {
+	if db.activeFile == nil {
+		return nil, nil
+	}
+	if err := db.syncBlobFile(); err != nil {
+		return nil, err
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return nil, err
+	}
+	operands := make(map[operandKey][]*data.LogRecordPos, len(db.operands))
+	for key, chain := range db.operands {
+		operands[key] = chain
+	}
+	blobRefs := make(map[operandKey]*data.LogRecordPos, len(db.blobRefs))
+	for key, blobPos := range db.blobRefs {
+		blobRefs[key] = blobPos
+	}
+	return &indexSnapshot{
+		fid:            db.activeFile.FileId,
+		offset:         db.activeFile.WriteOff,
+		nonMergeFileId: db.nonMergeFileId,
+		seqNo:          db.seqNo,
+		reclaimSize:    db.reclaimSize,
+		index:          db.index.Snapshot(),
+		operands:       operands,
+		blobRefs:       blobRefs,
+	}, nil
+}
+
+// 写入索引快照，先写入临时文件，完成之后替换旧的快照
+func (db *DB) writeIndexSnapshot(snapshot *indexSnapshot) error {
+	if snapshot == nil {
+		return nil
+	}
+	defer snapshot.index.Close()
+
+	tempPath := filepath.Join(db.options.DirPath, data.IndexSnapshotTempFileName)
+	_ = os.Remove(tempPath)
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.options.DirPath, data.IndexSnapshotTempFileName)
+	if err != nil {
+		return err
+	}
+	defer snapshotFile.Close()
+
+	meta := make([]byte, binary.MaxVarintLen64*5)
+	n := binary.PutUvarint(meta, uint64(snapshot.fid))
+	n += binary.PutVarint(meta[n:], snapshot.offset)
+	n += binary.PutUvarint(meta[n:], uint64(snapshot.nonMergeFileId))
+	n += binary.PutUvarint(meta[n:], snapshot.seqNo)
+	n += binary.PutVarint(meta[n:], snapshot.reclaimSize)
+	if err := db.writeIndexSnapshotRecord(snapshotFile, &data.LogRecord{
+		Key:   []byte(indexSnapshotKey),
+		Value: meta[:n],
+	}); err != nil {
+		return err
+	}
+
+	var count uint64
+	iter := snapshot.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		pos := iter.Value()
+		chain, ok := snapshot.operands[newOperandKey(pos)]
+		if !ok {
+			err = db.writeHintRecord(snapshotFile, iter.Key(), pos, snapshot.blobRefs[newOperandKey(pos)])
+		} else {
+			err = db.writeIndexSnapshotRecord(snapshotFile, &data.LogRecord{
+				Key:   iter.Key(),
+				Value: encodeOperandChain(chain, snapshot.blobRefs),
+				Type:  data.LogRecordMerge,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	end := make([]byte, binary.MaxVarintLen64)
+	if err := db.writeIndexSnapshotRecord(snapshotFile, &data.LogRecord{
+		Key:   []byte(indexSnapshotKey),
+		Value: end[:binary.PutUvarint(end, count)],
+		Type:  data.LogRecordTxnFinished,
+	}); err != nil {
+		return err
+	}
+	if err := snapshotFile.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, filepath.Join(db.options.DirPath, data.IndexSnapshotFileName))
+}
+
+func (db *DB) writeIndexSnapshotRecord(snapshotFile *data.DataFile, logRecord *data.LogRecord) error {
+	record, err := db.encryptLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	return snapshotFile.Write(encRecord)
+}
+
+// 编码操作数链，每条记录为 位置的长度 + 位置 + blob记录位置的长度 + blob记录位置
+func encodeOperandChain(chain []*data.LogRecordPos, blobRefs map[operandKey]*data.LogRecordPos) []byte {
+	var buf []byte
+	lenBuf := make([]byte, binary.MaxVarintLen32)
+	for _, pos := range chain {
+		posBuf := data.EncodeLogRecordPos(pos)
+		buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf, uint64(len(posBuf)))]...)
+		buf = append(buf, posBuf...)
+
+		var blobBuf []byte
+		if blobPos, ok := blobRefs[newOperandKey(pos)]; ok {
+			blobBuf = data.EncodeLogRecordPos(blobPos)
+		}
+		buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf, uint64(len(blobBuf)))]...)
+		buf = append(buf, blobBuf...)
+	}
+	return buf
+}
+
+func decodeOperandChain(buf []byte) ([]*data.LogRecordPos, []*data.LogRecordPos, error) {
+	var chain, blobs []*data.LogRecordPos
+	for len(buf) > 0 {
+		var positions [2]*data.LogRecordPos
+		for i := range positions {
+			size, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < size {
+				return nil, nil, errInvalidIndexSnapshot
+			}
+			if size > 0 {
+				positions[i] = data.DecodeLogRecordPos(buf[n : n+int(size)])
+			}
+			buf = buf[n+int(size):]
+		}
+		if positions[0] == nil {
+			return nil, nil, errInvalidIndexSnapshot
+		}
+		chain = append(chain, positions[0])
+		blobs = append(blobs, positions[1])
+	}
+	return chain, blobs, nil
+}
+
+// 加载索引快照，返回快照覆盖到的数据文件id和偏移
+// 快照不存在、损坏或者已经失效时返回 false，需要回放全部数据文件，非只读模式下删除失效的快照
+// 没有开启索引快照时同样删除快照，之后的写入会使它失效
+func (db *DB) loadIndexSnapshot() (bool, uint32, int64) {
+	snapshotPath := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return false, 0, 0
+	}
+	if !db.options.IndexSnapshot {
+		if !db.options.ReadOnly {
+			_ = os.Remove(snapshotPath)
+		}
+		return false, 0, 0
+	}
+
+	snapshot, entries, err := db.readIndexSnapshot()
+	if err != nil {
+		log.Printf("bitcask: ignore the index snapshot and replay all data files, %v", err)
+		if !db.options.ReadOnly {
+			_ = os.Remove(snapshotPath)
+		}
+		return false, 0, 0
+	}
+
+	now := time.Now().UnixNano()
+	for _, entry := range entries {
+		pos := entry.chain[len(entry.chain)-1]
+		if pos.IsExpired(now) {
+			for _, chainPos := range entry.chain {
+				snapshot.reclaimSize += int64(chainPos.Size)
+			}
+			continue
+		}
+		for i, chainPos := range entry.chain {
+			if entry.blobs[i] != nil {
+				db.addBlobRef(chainPos, entry.blobs[i])
+			}
+		}
+		if len(entry.chain) > 1 {
+			db.operands[newOperandKey(pos)] = entry.chain
+		}
+		db.index.Put(entry.key, pos)
+	}
+	db.seqNo = snapshot.seqNo
+	db.reclaimSize = snapshot.reclaimSize
+	return true, snapshot.fid, snapshot.offset
+}
+
+// 读取并校验索引快照
+func (db *DB) readIndexSnapshot() (*indexSnapshot, []*indexSnapshotEntry, error) {
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.options.DirPath, data.IndexSnapshotFileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snapshotFile.Close()
+
+	reader, err := snapshotFile.NewLogRecordReader(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	readRecord := func() (*data.LogRecord, error) {
+		logRecord, _, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errInvalidIndexSnapshot
+			}
+			return nil, err
+		}
+		if err := db.decryptLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+		return logRecord, nil
+	}
+
+	// 校验快照覆盖的位置
+	meta, err := readRecord()
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := decodeIndexSnapshotMeta(meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	if snapshot.nonMergeFileId != db.nonMergeFileId {
+		return nil, nil, fmt.Errorf("%w: the data files have been merged", errInvalidIndexSnapshot)
+	}
+	dataFile := db.olderFiles[snapshot.fid]
+	if db.activeFile != nil && db.activeFile.FileId == snapshot.fid {
+		dataFile = db.activeFile
+	}
+	if dataFile == nil {
+		return nil, nil, fmt.Errorf("%w: data file %d not found", errInvalidIndexSnapshot, snapshot.fid)
+	}
+	if size, err := dataFile.IoManager.Size(); err != nil || size < snapshot.offset {
+		return nil, nil, fmt.Errorf("%w: data file %d is shorter than the snapshot", errInvalidIndexSnapshot, snapshot.fid)
+	}
+
+	var entries []*indexSnapshotEntry
+	for {
+		logRecord, err := readRecord()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entry := &indexSnapshotEntry{key: logRecord.Key}
+		switch {
+		case logRecord.Type == data.LogRecordTxnFinished:
+			// 快照完整
+			count, n := binary.Uvarint(logRecord.Value)
+			if n <= 0 || count != uint64(len(entries)) {
+				return nil, nil, errInvalidIndexSnapshot
+			}
+			return snapshot, entries, nil
+		case logRecord.Type == data.LogRecordMerge:
+			if entry.chain, entry.blobs, err = decodeOperandChain(logRecord.Value); err != nil {
+				return nil, nil, err
+			}
+		case logRecord.Flags&data.LogRecordBlob != 0:
+			pos, blobPos := decodeBlobHint(logRecord.Value)
+			entry.chain = []*data.LogRecordPos{pos}
+			entry.blobs = []*data.LogRecordPos{blobPos}
+		default:
+			entry.chain = []*data.LogRecordPos{data.DecodeLogRecordPos(logRecord.Value)}
+			entry.blobs = []*data.LogRecordPos{nil}
+		}
+		if len(entry.chain) == 0 {
+			return nil, nil, errInvalidIndexSnapshot
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func decodeIndexSnapshotMeta(logRecord *data.LogRecord) (*indexSnapshot, error) {
+	if string(logRecord.Key) != indexSnapshotKey {
+		return nil, errInvalidIndexSnapshot
+	}
+
+	buf := logRecord.Value
+	var invalid bool
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			invalid = true
+			return 0
+		}
+		buf = buf[n:]
+		return v
+	}
+	varint := func() int64 {
+		v, n := binary.Varint(buf)
+		if n <= 0 {
+			invalid = true
+			return 0
+		}
+		buf = buf[n:]
+		return v
+	}
+
+	snapshot := &indexSnapshot{}
+	snapshot.fid = uint32(uvarint())
+	snapshot.offset = varint()
+	snapshot.nonMergeFileId = uint32(uvarint())
+	snapshot.seqNo = uvarint()
+	snapshot.reclaimSize = varint()
+	if invalid {
+		return nil, errInvalidIndexSnapshot
+	}
+	return snapshot, nil
+}
+
+// 后台定期保存索引快照
+func (db *DB) startIndexSnapshot() {
+	db.indexSnapshotWg.Add(1)
+	go db.runIndexSnapshot()
+}
+
+func (db *DB) runIndexSnapshot() {
+	defer db.indexSnapshotWg.Done()
+
+	ticker := time.NewTicker(db.options.IndexSnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.closeCh:
+			return
+		case <-ticker.C:
+			if err := db.saveIndexSnapshot(); err != nil {
+				log.Printf("bitcask: failed to save the index snapshot, %v", err)
+			}
+		}
+	}
+}
+
+// 保存索引快照，只有复制内存状态时持有锁
+// 索引基于写时复制生成快照，代价与索引大小无关，持有锁的时间只与操作数链和blob引用的数量有关
+func (db *DB) saveIndexSnapshot() error {
+	db.mu.Lock()
+	snapshot, err := db.captureIndexSnapshot()
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return db.writeIndexSnapshot(snapshot)
+}
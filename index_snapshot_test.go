@@ -0,0 +1,135 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_IndexSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	opts.MergeOperator = counterMergeOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Put([]byte("operand"), []byte("1")))
+	assert.Nil(t, db.MergeValue([]byte("operand"), []byte("2")))
+	assert.Nil(t, db.Close())
+
+	snapshotFile := filepath.Join(dir, data.IndexSnapshotFileName)
+	_, err = os.Stat(snapshotFile)
+	assert.Nil(t, err)
+
+	// 快照之后的写入在打开时回放
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2001, len(db.ListKeys()))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.MergeValue([]byte("operand"), []byte("3")))
+
+	check := func(db *DB) {
+		assert.Equal(t, 1902, len(db.ListKeys()))
+		_, err := db.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get(utils.GetTestKey(2000))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch"), val)
+		val, err = db.Get([]byte("operand"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("6"), val)
+	}
+	check(db)
+
+	// 模拟异常退出，只保留打开时加载的旧快照
+	old, err := os.ReadFile(snapshotFile)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(snapshotFile, old, 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// 快照损坏时回放全部数据文件
+	assert.Nil(t, db.Close())
+	buf, err := os.ReadFile(snapshotFile)
+	assert.Nil(t, err)
+	buf[len(buf)/2]++
+	assert.Nil(t, os.WriteFile(snapshotFile, buf, 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_IndexSnapshot_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// merge之后快照失效
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("after merge")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after merge"), val)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_IndexSnapshot_Background(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot-background")
+	opts.DirPath = dir
+	opts.IndexSnapshot = true
+	opts.IndexSnapshotInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	snapshotFile := filepath.Join(dir, data.IndexSnapshotFileName)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(snapshotFile)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -3,21 +3,34 @@ package bitcask_go
 import (
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
 type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
 	options   IteratorOptions
+	readTime  int64 // 判断是否过期的时间点，为0时使用当前时间
 }
 
+// 创建迭代器，BTree 和 B+树索引时遍历创建时的索引，ART 索引时之后的写入可能对迭代器可见
 func (db *DB) NewIterator(options IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(options.Reverse)
-	return &Iterator{
+	// 持有db.mu创建迭代器，不会读到组提交中还没有持久化的写入
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return newIterator(db, db.index, options, 0)
+}
+
+func newIterator(db *DB, idx index.Indexer, options IteratorOptions, readTime int64) *Iterator {
+	indexIter := idx.Iterator(options.Reverse)
+	it := &Iterator{
 		indexIter: indexIter,
 		db:        db,
 		options:   options,
+		readTime:  readTime,
 	}
+	it.skipToNext()
+	return it
 }
 
 // 回到起始位置
@@ -62,16 +75,20 @@ func (it *Iterator) Close() {
 	it.indexIter.Close()
 }
 
-// 跳过不符合前缀的key
+// 跳过不符合前缀以及已过期的key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
+	now := it.readTime
+	if now == 0 {
+		now = time.Now().UnixNano()
 	}
 
-	for ; it.Valid(); it.Next() {
+	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired(now) {
+			continue
+		}
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(key[:prefixLen], it.options.Prefix) == 0 {
+		if prefixLen == 0 || (prefixLen <= len(key) && bytes.Compare(key[:prefixLen], it.options.Prefix) == 0) {
 			break
 		}
 	}
@@ -9,6 +9,9 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
+
+	"github.com/gofrs/flock"
 )
 
 const (
@@ -17,11 +20,16 @@ const (
 )
 
 func (db *DB) Merge() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
 
 	if db.activeFile == nil {
+		db.mu.Unlock()
 		return nil
 	}
-	db.mu.Lock()
 
 	// 如果正在合并,则退出
 	if db.isMerging {
@@ -29,12 +37,13 @@ func (db *DB) Merge() error {
 		return ErrMergeIsProcess
 	}
 
-	// 查看可以merge的数据量是否达到阈值
+	// 查看可以merge的数据量是否达到阈值，blob文件不参与merge
 	totalSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
+	totalSize -= db.blobDiskSize()
 	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
@@ -58,20 +67,14 @@ func (db *DB) Merge() error {
 		db.isMerging = false
 	}()
 
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
-		db.mu.Unlock()
-		return err
-	}
-
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
+	// 持久化当前活跃文件，并打开新的活跃文件
+	if err := db.sealActiveFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
 
 	nonMergeFileId := db.activeFile.FileId
+	reclaimSize := db.reclaimSize
 
 	// 取出所有需要merge的文件
 	var mergeFiles []*data.DataFile
@@ -102,46 +105,83 @@ func (db *DB) Merge() error {
 	mergeOption := db.options
 	mergeOption.DirPath = mergePath
 	mergeOption.SyncWrites = false
+	mergeOption.AutoMergeInterval = 0
+	// 指针记录直接复制，不重新写入blob文件
+	mergeOption.ValueThreshold = 0
+	mergeOption.BloomFilterFalsePositiveRate = 0
+	mergeOption.IndexSnapshot = false
 	mergeDB, err := Open(mergeOption)
 	if err != nil {
 		return err
 	}
-
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
+		_ = mergeDB.Close()
 		return err
 	}
 
-	// 遍历每个数据文件
+	// 出错时也需要关闭，释放文件句柄和merge目录的文件锁，否则下次merge无法删除merge目录
+	var mergeFilesClosed bool
+	defer func() {
+		if !mergeFilesClosed {
+			_ = hintFile.Close()
+			_ = mergeDB.Close()
+		}
+	}()
+
+	// 遍历每个数据文件，按照 MergeBytesPerSecond 限速
+	now := time.Now().UnixNano()
+	throttle := newMergeThrottle(db.options.MergeBytesPerSecond)
 	for _, file := range mergeFiles {
 		var offset int64 = 0
 		for {
-			logRecord, size, err := file.ReadLogRecord(offset)
+			logRecord, size, err := db.readLogRecord(file, offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
-				return nil
+				return err
 			}
+			ioSize := size
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
-			// 与内存中的索引位置进行比较
+			// 与内存中的索引位置进行比较，已过期的数据直接丢弃
 			if logRecordPos != nil &&
-				logRecordPos.Fid == file.FileId &&
-				logRecordPos.Offset == offset {
-				// 清除事务标记
+				!logRecordPos.IsExpired(now) &&
+				db.isLiveForMerge(logRecordPos, file.FileId, offset, nonMergeFileId) {
+				// 操作数合并为完整的值
+				if logRecord.Type == data.LogRecordMerge {
+					value, err := db.mergeOperandsUpTo(realKey, logRecordPos, file.FileId, offset)
+					if err != nil {
+						return err
+					}
+					logRecord.Value = value
+					logRecord.Type = data.LogRecordNormal
+				}
+
+				// 清除事务标记，merge之后之前的记录不再存在，搬移标记也需要清除
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				logRecord.Flags &^= data.LogRecordRelocated
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
 					return err
 				}
+				ioSize += int64(pos.Size)
 
 				// 将当前位置写入hint文件，相当于btree中的索引
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				var blobPos *data.LogRecordPos
+				if logRecord.Flags&data.LogRecordBlob != 0 {
+					blobPos = data.DecodeLogRecordPos(logRecord.Value)
+				}
+				if err := db.writeHintRecord(hintFile, realKey, pos, blobPos); err != nil {
 					return err
 				}
 			}
 
+			if err := throttle.wait(ioSize, db.closeCh); err != nil {
+				return err
+			}
+
 			// 更新offset
 			offset += size
 		}
@@ -153,11 +193,35 @@ func (db *DB) Merge() error {
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	mergeFilesClosed = true
+	if err := hintFile.Close(); err != nil {
+		_ = mergeDB.Close()
+		return err
+	}
+	if err := mergeDB.Close(); err != nil {
+		return err
+	}
+
+	if err := writeMergeFinishedFile(mergePath, nonMergeFileId); err != nil {
+		return err
+	}
+
+	// merge之前的无效数据在重启时清理，自动merge不再重复计算
+	db.mu.Lock()
+	db.mergedReclaimSize = reclaimSize
+	db.mu.Unlock()
+
+	return nil
+}
 
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+// 写入merge完成标记，记录没有参与merge的第一个文件id
+func writeMergeFinishedFile(dirPath string, nonMergeFileId uint32) error {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return err
 	}
+	defer mergeFinishedFile.Close()
+
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
@@ -167,11 +231,42 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Write(encRecord); err != nil {
 		return err
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
-		return err
+	return mergeFinishedFile.Sync()
+}
+
+// 判断数据文件中 fid/offset 处的记录在merge之后是否需要保留
+// 除了索引指向的最新记录，操作数链跨越merge边界时，边界之前的最后一条记录也需要保留，
+// 它会被合并成完整的值，作为之后操作数的基础
+func (db *DB) isLiveForMerge(latestPos *data.LogRecordPos, fid uint32, offset int64, nonMergeFileId uint32) bool {
+	if latestPos.Fid == fid && latestPos.Offset == offset {
+		return true
+	}
+	if latestPos.Fid < nonMergeFileId {
+		return false
 	}
 
-	return nil
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	chain := db.operands[newOperandKey(latestPos)]
+	for i := 0; i < len(chain)-1; i++ {
+		if chain[i].Fid == fid && chain[i].Offset == offset {
+			return chain[i+1].Fid >= nonMergeFileId
+		}
+	}
+	return false
+}
+
+// 合并操作数链中截止到 fid/offset 处的操作数
+func (db *DB) mergeOperandsUpTo(key []byte, latestPos *data.LogRecordPos, fid uint32, offset int64) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	chain := db.operands[newOperandKey(latestPos)]
+	for i, pos := range chain {
+		if pos.Fid == fid && pos.Offset == offset {
+			return db.mergeOperands(key, chain[:i+1])
+		}
+	}
+	return nil, ErrDataDirectoryCorrupted
 }
 
 // 生成同级目录下的-merge目录
@@ -186,8 +281,11 @@ func (db *DB) loadMergeFiles() error {
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
+	var keepMergeDir bool
 	defer func() {
-		_ = os.RemoveAll(mergePath)
+		if !keepMergeDir {
+			_ = os.RemoveAll(mergePath)
+		}
 	}()
 
 	dirEntries, err := os.ReadDir(mergePath)
@@ -201,7 +299,6 @@ func (db *DB) loadMergeFiles() error {
 	for _, entry := range dirEntries {
 		if entry.Name() == data.MergeFinishedFileName {
 			mergeFinished = true
-			break
 		}
 		if entry.Name() == data.SeqNoFileName || entry.Name() == fileLockName {
 			continue
@@ -213,18 +310,36 @@ func (db *DB) loadMergeFiles() error {
 		return nil
 	}
 
+	// 只读实例可能正在读取旧的数据文件，等没有只读实例时再替换
+	readerLock := flock.New(filepath.Join(db.options.DirPath, readerLockName))
+	hold, err := readerLock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !hold {
+		keepMergeDir = true
+		return nil
+	}
+	defer func() {
+		_ = readerLock.Unlock()
+	}()
+
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
 		return err
 	}
 
-	// 删除旧的数据文件
+	// 删除旧的数据文件以及对应的hint文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+		for _, fileName := range []string{
+			data.GetDataFileName(db.options.DirPath, fileId),
+			data.GetDataFileHintName(db.options.DirPath, fileId),
+		} {
+			if _, err := os.Stat(fileName); err == nil {
+				if err := os.Remove(fileName); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -241,11 +356,27 @@ func (db *DB) loadMergeFiles() error {
 	return nil
 }
 
+// 加载最近一次merge的非merge文件id，没有发生过merge时为0
+func (db *DB) loadNonMergeFileId() error {
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	db.nonMergeFileId = nonMergeFileId
+	return nil
+}
+
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	defer mergeFinishedFile.Close()
 
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
@@ -270,23 +401,38 @@ func (db *DB) loadIndexFromHintFile() error {
 		return err
 	}
 
-	var offset int64 = 0
+	defer hintFile.Close()
+	reader, err := hintFile.NewLogRecordReader(0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
 	for {
-		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		logRecord, _, err := reader.Next()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
+		if err := db.decryptLogRecord(logRecord); err != nil {
+			return err
+		}
 
-		// 解码
-		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
-
-		// 更新offset
-		offset += size
-
+		// 解码，跳过已过期的数据
+		if logRecord.Flags&data.LogRecordBlob != 0 {
+			pos, blobPos := decodeBlobHint(logRecord.Value)
+			if !pos.IsExpired(now) {
+				db.index.Put(logRecord.Key, pos)
+				db.addBlobRef(pos, blobPos)
+			}
+		} else {
+			pos := data.DecodeLogRecordPos(logRecord.Value)
+			if !pos.IsExpired(now) {
+				db.index.Put(logRecord.Key, pos)
+			}
+		}
 	}
 
 	return nil
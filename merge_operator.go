@@ -0,0 +1,153 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"time"
+)
+
+// 单个key连续操作数的最大数量，超过后直接写入合并后的完整值，避免读取时回放过多记录
+const maxOperandChainLength = 64
+
+// 合并操作符，用于实现计数器、集合追加等读-改-写操作
+type MergeOperator interface {
+	// FullMerge 将操作数按写入顺序合并到已有的值上，existing 为 nil 表示key不存在
+	FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// 记录在数据文件中的位置，用于查找操作数链和blob引用
+type operandKey struct {
+	fid    uint32
+	offset int64
+}
+
+func newOperandKey(pos *data.LogRecordPos) operandKey {
+	return operandKey{fid: pos.Fid, offset: pos.Offset}
+}
+
+// 追加一个操作数，读取时由 Options.MergeOperator 合并到已有的值上
+// 操作数继承已有值的过期时间
+func (db *DB) MergeValue(key []byte, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.MergeOperator == nil {
+		return ErrMergeOperatorNotSet
+	}
+
+	return db.writeWithSync(db.options.SyncWrites, func() error {
+		return db.mergeValue(key, operand)
+	})
+}
+
+// 追加一个操作数并更新操作数链，调用方需要持有db.mu
+func (db *DB) mergeValue(key []byte, operand []byte) error {
+	var chain []*data.LogRecordPos
+	var expiresAt int64
+	oldPos := db.index.Get(key)
+	if oldPos != nil {
+		if oldPos.IsExpired(time.Now().UnixNano()) {
+			db.discardPos(oldPos)
+			oldPos = nil
+		} else {
+			expiresAt = oldPos.ExpiresAt
+			if chain = db.operands[newOperandKey(oldPos)]; chain == nil {
+				chain = []*data.LogRecordPos{oldPos}
+			}
+		}
+	}
+
+	// b+树索引启动时不回放数据文件，无法重建操作数链，直接写入合并后的值
+	// 操作数过多时同样直接写入合并后的值
+	if db.options.IndexType == BPlusTree || len(chain) >= maxOperandChainLength {
+		var existing []byte
+		if oldPos != nil {
+			value, err := db.getValueByPosition(oldPos)
+			if err != nil {
+				return err
+			}
+			existing = value
+		}
+		value, err := db.options.MergeOperator.FullMerge(key, existing, [][]byte{operand})
+		if err != nil {
+			return err
+		}
+		return db.put(key, value, expiresAt)
+	}
+
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:     operand,
+		Type:      data.LogRecordMerge,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	db.addOperand(key, chain, pos)
+	db.notifyWatch(WatchEventMerge, key, operand)
+	return nil
+}
+
+// 将操作数位置加入操作数链并更新索引，chain 为之前的操作数链（可能以完整值开头）
+// 之前的链仍然被快照和索引快照引用，复制之后再追加，避免共享底层数组
+func (db *DB) addOperand(key []byte, chain []*data.LogRecordPos, pos *data.LogRecordPos) {
+	db.operands[newOperandKey(pos)] = append(append([]*data.LogRecordPos(nil), chain...), pos)
+	db.index.Put(key, pos)
+}
+
+// 记录被覆盖或删除后，统计可回收的空间，并释放其操作数链
+func (db *DB) discardPos(oldPos *data.LogRecordPos) {
+	if oldPos == nil {
+		return
+	}
+
+	chain, ok := db.operands[newOperandKey(oldPos)]
+	if !ok {
+		db.reclaimSize += int64(oldPos.Size)
+		db.removeBlobRef(oldPos)
+		db.evictCache(oldPos)
+		return
+	}
+	for _, pos := range chain {
+		db.reclaimSize += int64(pos.Size)
+		db.removeBlobRef(pos)
+		db.evictCache(pos)
+	}
+
+	// 快照可能仍然引用操作数链，等快照全部释放后再删除
+	if len(db.snapshots) > 0 {
+		db.staleOperands = append(db.staleOperands, chain)
+		return
+	}
+	db.removeOperands(chain)
+}
+
+// 删除操作数链在内存中的记录
+func (db *DB) removeOperands(chain []*data.LogRecordPos) {
+	for _, pos := range chain {
+		delete(db.operands, newOperandKey(pos))
+	}
+}
+
+// 读取操作数链并合并，调用方需要持有db.mu
+func (db *DB) mergeOperands(key []byte, chain []*data.LogRecordPos) ([]byte, error) {
+	if db.options.MergeOperator == nil {
+		return nil, ErrMergeOperatorNotSet
+	}
+
+	var existing []byte
+	operands := make([][]byte, 0, len(chain))
+	for _, pos := range chain {
+		logRecord, err := db.readLogRecordAt(pos)
+		if err != nil {
+			return nil, err
+		}
+		if logRecord.Type == data.LogRecordMerge {
+			operands = append(operands, logRecord.Value)
+		} else {
+			existing = logRecord.Value
+		}
+	}
+
+	return db.options.MergeOperator.FullMerge(key, existing, operands)
+}
@@ -0,0 +1,129 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 计数器合并操作符，操作数为十进制整数
+type counterMergeOperator struct{}
+
+func (counterMergeOperator) FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int
+	if existing != nil {
+		n, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, err
+		}
+		sum = n
+	}
+	for _, operand := range operands {
+		n, err := strconv.Atoi(string(operand))
+		if err != nil {
+			return nil, err
+		}
+		sum += n
+	}
+	return []byte(strconv.Itoa(sum)), nil
+}
+
+func TestDB_MergeValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operator")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeOperator = counterMergeOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	getValue := func(db *DB, key string) string {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		return string(val)
+	}
+
+	// 1.在已有的值上追加操作数
+	assert.Nil(t, db.Put([]byte("k1"), []byte("10")))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.MergeValue([]byte("k1"), []byte("1")))
+	}
+	assert.Equal(t, "13", getValue(db, "k1"))
+
+	// 2.key不存在时直接合并操作数
+	assert.Nil(t, db.MergeValue([]byte("k2"), []byte("5")))
+	assert.Equal(t, "5", getValue(db, "k2"))
+
+	// 3.快照中的操作数链不受之后写入的影响
+	snap, err := db.NewSnapshot()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("100")))
+	val, err := snap.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "13", string(val))
+	snap.Release()
+	assert.Equal(t, "100", getValue(db, "k1"))
+	assert.Nil(t, db.MergeValue([]byte("k1"), []byte("1")))
+
+	// 4.重启之后重建操作数链
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "101", getValue(db2, "k1"))
+	assert.Equal(t, "5", getValue(db2, "k2"))
+
+	// 5.merge之后操作数被合并为完整的值
+	assert.Nil(t, db2.Merge())
+	assert.Nil(t, db2.MergeValue([]byte("k1"), []byte("1")))
+	assert.Equal(t, "102", getValue(db2, "k1"))
+	assert.Nil(t, db2.Close())
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db3)
+	assert.Equal(t, "102", getValue(db3, "k1"))
+	assert.Equal(t, "5", getValue(db3, "k2"))
+	assert.Nil(t, db3.Delete([]byte("k2")))
+	_, err = db3.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	// 只有 k1 在merge之后追加了操作数
+	assert.Equal(t, 1, len(db3.operands))
+}
+
+func TestDB_MergeValueWithoutOperator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operator-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.MergeValue([]byte("k1"), []byte("1"))
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+}
+
+func TestDB_AddOperandCopiesChain(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operator-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 之前的链还有剩余容量时，两次追加不能互相覆盖
+	chain := make([]*data.LogRecordPos, 1, 4)
+	chain[0] = &data.LogRecordPos{Fid: 0, Offset: 0}
+	pos1 := &data.LogRecordPos{Fid: 0, Offset: 10}
+	pos2 := &data.LogRecordPos{Fid: 0, Offset: 20}
+	db.mu.Lock()
+	db.addOperand([]byte("key"), chain, pos1)
+	db.addOperand([]byte("key"), chain, pos2)
+	db.mu.Unlock()
+	assert.Equal(t, []*data.LogRecordPos{chain[0], pos1}, db.operands[newOperandKey(pos1)])
+	assert.Equal(t, []*data.LogRecordPos{chain[0], pos2}, db.operands[newOperandKey(pos2)])
+}
@@ -1,15 +1,44 @@
 package bitcask_go
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 type Options struct {
-	DirPath            string      // 数据库数据目录
-	DataFileSize       int64       // 数据文件大小
-	SyncWrites         bool        // 是否持久化
-	BytesPerSync       uint        // 每次同步的字节数
-	IndexType          IndexerType // 索引类型
-	MMapAtStartup      bool        // 是否在启动时内存映射数据文件
-	DataFileMergeRatio float32     // 数据文件合并阈值
+	DirPath            string        // 数据库数据目录
+	DataFileSize       int64         // 数据文件大小
+	SyncWrites         bool          // 是否持久化
+	BytesPerSync       uint          // 每次同步的字节数
+	IndexType          IndexerType   // 索引类型
+	MMapAtStartup      bool          // 是否在启动时内存映射数据文件
+	DataFileMergeRatio float32       // 数据文件合并阈值
+	MergeOperator      MergeOperator // 合并操作符，为空时不能使用 MergeValue
+	WatchBufferSize    int           // 每个监听者的事件缓冲区大小
+
+	AutoMergeInterval    time.Duration // 后台自动merge的检查间隔，<= 0 表示不开启
+	AutoMergeWindowStart time.Duration // 允许自动merge的时间段的开始时间，相对于当天0点
+	AutoMergeWindowEnd   time.Duration // 允许自动merge的时间段的结束时间，与开始时间相同表示不限制，小于开始时间表示跨越0点
+	MergeBytesPerSecond  int64         // merge每秒读写的字节数上限，<= 0 表示不限制
+
+	ReadOnly bool // 只读模式，可以与其他进程同时打开同一个目录，不支持b+树索引
+
+	RecoveryMode RecoveryMode // 启动时发现数据文件损坏的处理方式
+
+	Compression          Compressor // value的压缩算法，为空时不压缩，更换算法之后由merge重新压缩旧数据
+	CompressionThreshold int        // 小于该大小的value不压缩
+
+	Encryption KeyProvider // 使用AES-GCM加密key和value的密钥，为空时不加密，轮换密钥之后由merge重新加密旧数据，不支持b+树索引
+
+	ValueThreshold int     // 大于该大小的value写入单独的blob文件，<= 0 表示不分离，不支持b+树索引
+	BlobMergeRatio float32 // blob文件中无效数据的比例达到该阈值时由 CompactBlobs 回收
+
+	ValueCacheSize int64 // 缓存读取过的记录使用的内存上限 B，<= 0 表示不缓存
+
+	BloomFilterFalsePositiveRate float64 // 布隆过滤器的误判率，<= 0 表示不使用布隆过滤器
+
+	IndexSnapshot         bool          // 关闭时保存索引快照，打开时只回放快照之后的日志，不支持b+树索引
+	IndexSnapshotInterval time.Duration // 后台定期保存索引快照的间隔，<= 0 表示只在关闭时保存
 }
 
 type IteratorOptions struct {
@@ -22,6 +51,19 @@ type WriteBatchOptions struct {
 	SyncWrites  bool // 是否持久化
 }
 
+type RecoveryMode = int8
+
+const (
+	// 截断活跃文件末尾不完整的记录，旧的数据文件损坏时返回错误
+	RecoveryTruncateTail RecoveryMode = iota
+
+	// 任何损坏都返回错误，不修改数据文件
+	RecoveryStrict
+
+	// 截断活跃文件末尾不完整的记录，旧的数据文件损坏时忽略损坏位置之后的数据
+	RecoverySkipCorrupted
+)
+
 type IndexerType = int8
 
 const (
@@ -38,6 +80,12 @@ var DefaultOptions = Options{
 	IndexType:          BTree,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5,
+	WatchBufferSize:    1024,
+	RecoveryMode:       RecoveryTruncateTail,
+
+	CompressionThreshold: 64,
+
+	BlobMergeRatio: 0.5,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -0,0 +1,93 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"time"
+)
+
+// 只读模式下加载写入实例在打开之后追加的数据，包括活跃文件中新写入的记录和新创建的数据文件
+// 活跃文件末尾还没有写完整的记录在下次刷新时重新读取，非只读模式下直接返回
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 新写入的指针记录可能引用新创建的blob文件
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+
+	fileIds, err := listDataFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if db.pendingTxnRecords == nil {
+		db.pendingTxnRecords = make(map[uint64][]*data.TransactionRecord)
+	}
+
+	// 打开之后才创建的第一个数据文件
+	if db.activeFile == nil {
+		if len(fileIds) == 0 {
+			return nil
+		}
+		if err := db.openRefreshedFile(uint32(fileIds[0])); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		// 唤醒等待新日志的变更流
+		if db.logUpdated != nil {
+			close(db.logUpdated)
+			db.logUpdated = nil
+		}
+	}()
+
+	now := time.Now().UnixNano()
+	for {
+		offset, err := db.replayDataFile(db.activeFile, db.activeFile.WriteOff, db.pendingTxnRecords, now)
+		db.activeFile.WriteOff = offset
+
+		nextFileId, ok := nextDataFileId(fileIds, db.activeFile.FileId)
+		if !ok {
+			// 写入实例可能正在写这条记录
+			if err == data.ErrInvalidCRC {
+				return nil
+			}
+			return err
+		}
+		// 写入实例切换活跃文件之前已经写完了旧文件，旧文件中不应该有不完整的记录
+		if err != nil {
+			return err
+		}
+
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		if err := db.openRefreshedFile(nextFileId); err != nil {
+			return err
+		}
+	}
+}
+
+// 打开新的数据文件作为活跃文件，调用方需要持有db.mu
+func (db *DB) openRefreshedFile(fileId uint32) error {
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	db.activeFile = dataFile
+	return nil
+}
+
+// 获取 fileIds 中 fileId 之后的下一个文件id
+func nextDataFileId(fileIds []int, fileId uint32) (uint32, bool) {
+	for _, fid := range fileIds {
+		if uint32(fid) > fileId {
+			return uint32(fid), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// 多个只读实例可以与写入实例同时打开
+	readOpts := opts
+	readOpts.ReadOnly = true
+	reader1, err := Open(readOpts)
+	assert.Nil(t, err)
+	defer reader1.Close()
+	reader2, err := Open(readOpts)
+	assert.Nil(t, err)
+	defer reader2.Close()
+
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	assert.Equal(t, 100, len(reader1.ListKeys()))
+	_, err = reader1.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+
+	// 只读实例拒绝写入
+	assert.Equal(t, ErrReadOnly, reader1.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, reader1.Delete(utils.GetTestKey(10)))
+	assert.Equal(t, ErrReadOnly, reader1.Merge())
+	wb := reader1.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrReadOnly, wb.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, wb.Delete(utils.GetTestKey(10)))
+	txn := reader1.Begin()
+	assert.Nil(t, txn.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, txn.Commit())
+
+	// 写入实例追加数据并切换活跃文件之后刷新
+	for i := 100; i < 400; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+
+	_, err = reader1.Get(utils.GetTestKey(300))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, reader1.Refresh())
+	assert.Equal(t, 400, len(reader1.ListKeys()))
+	_, err = reader1.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := reader1.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), value)
+
+	// 关闭只读实例不会写入事务序列号文件
+	assert.Nil(t, reader2.Close())
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_ReadOnlyDefersMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only-merge")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	readOpts := opts
+	readOpts.ReadOnly = true
+	reader, err := Open(readOpts)
+	assert.Nil(t, err)
+
+	// 存在只读实例时不替换数据文件
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db.ListKeys()))
+	assert.Equal(t, 50, len(reader.ListKeys()))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, reader.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, db.nonMergeFileId > 0)
+	assert.Equal(t, 50, len(db.ListKeys()))
+}
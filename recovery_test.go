@@ -0,0 +1,187 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 在数据文件末尾追加数据，模拟写入时崩溃
+func appendToDataFile(t *testing.T, dirPath string, fileId uint32, buf []byte) {
+	f, err := os.OpenFile(data.GetDataFileName(dirPath, fileId), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func TestDB_RecoverTornTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	fileId := db.activeFile.FileId
+	validSize := db.activeFile.WriteOff
+	assert.Nil(t, db.Close())
+
+	// 只写入了一半的记录
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("torn-key"), nonTransactionSeqNo),
+		Value: utils.RandomValue(64),
+	})
+	appendToDataFile(t, dir, fileId, encRecord[:len(encRecord)/2])
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, validSize, db.activeFile.WriteOff)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	stat, err := os.Stat(data.GetDataFileName(dir, fileId))
+	assert.Nil(t, err)
+	assert.Equal(t, validSize, stat.Size())
+
+	// 截断之后可以继续写入
+	assert.Nil(t, db.Put([]byte("new-key"), []byte("new-value")))
+	assert.Nil(t, db.Close())
+
+	// 最后一条记录校验失败
+	encRecord[len(encRecord)-1] ^= 0xff
+	appendToDataFile(t, dir, fileId, encRecord)
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(db.ListKeys()))
+	value, err := db.Get([]byte("new-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), value)
+}
+
+func TestDB_RecoverStrict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(64)))
+	fileId := db.activeFile.FileId
+	assert.Nil(t, db.Close())
+	defer os.RemoveAll(dir)
+
+	appendToDataFile(t, dir, fileId, []byte("garbage-tail"))
+
+	opts.RecoveryMode = RecoveryStrict
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataDirectoryCorrupted))
+}
+
+func TestDB_RecoverCorruptedOlderFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-3")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	assert.Nil(t, db.Close())
+	defer os.RemoveAll(dir)
+
+	// 损坏第一个数据文件中间的数据
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 16*1024)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	// 从hint文件加载时不读取数据文件，删除hint文件之后启动时回放数据文件
+	assert.Nil(t, os.Remove(data.GetDataFileHintName(dir, 0)))
+
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataDirectoryCorrupted))
+
+	// 忽略损坏位置之后的数据
+	opts.RecoveryMode = RecoverySkipCorrupted
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	keys := len(db.ListKeys())
+	assert.True(t, keys > 0 && keys < 1000)
+	_, err = db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_RecoverCorruptedActiveFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-4")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	fileId := db.activeFile.FileId
+	size := db.activeFile.WriteOff
+	assert.Nil(t, db.Close())
+	defer os.RemoveAll(dir)
+
+	corrupt := func(offset int64) {
+		f, err := os.OpenFile(data.GetDataFileName(dir, fileId), os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		_, err = f.WriteAt([]byte("corrupted"), offset)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+
+	// 活跃文件中间损坏时不截断之后的记录
+	corrupt(size / 2)
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataDirectoryCorrupted))
+	stat, err := os.Stat(data.GetDataFileName(dir, fileId))
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+
+	// 第一条记录损坏时不截断整个文件
+	corrupt(0)
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataDirectoryCorrupted))
+	stat, err = os.Stat(data.GetDataFileName(dir, fileId))
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+}
+
+func TestDB_RecoverTornLastRecord(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-5")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	lastOffset := db.activeFile.WriteOff
+	assert.Nil(t, db.Put([]byte("last-key"), utils.RandomValue(64)))
+	fileId := db.activeFile.FileId
+	size := db.activeFile.WriteOff
+	assert.Nil(t, db.Close())
+
+	// 最后一条记录只写入了一部分
+	assert.Nil(t, os.Truncate(data.GetDataFileName(dir, fileId), size-10))
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, lastOffset, db.activeFile.WriteOff)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	_, err = db.Get([]byte("last-key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,350 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// 数据文件中无法读取的字节范围 [Start, End)
+type LostRange struct {
+	Fid   uint32
+	Start int64
+	End   int64
+	Blob  bool // 范围是否位于blob文件中
+}
+
+// 修复结果
+type RepairReport struct {
+	OutputDir         string
+	ScannedFiles      int         // 扫描的数据文件数量
+	ScannedBlobFiles  int         // 扫描的blob文件数量
+	SalvagedRecords   int         // 成功读取的记录数量
+	DroppedTxnRecords int         // 未完成的事务中丢弃的记录数量
+	LiveKeys          int         // 写入新目录的key数量
+	SkippedKeys       int         // blob记录无法读取而丢弃的key数量
+	LostRanges        []LostRange // 每个数据文件和blob文件中丢失的字节范围
+}
+
+// 离线修复数据目录，逐条扫描所有数据文件，跳过损坏的数据，从下一条校验通过的记录继续读取，
+// 将读取到的有效数据写入新的目录 outputDir，并重新生成 hint-index 和 seq-no 文件
+// 没有完成标记的事务数据会被丢弃，引用的blob记录损坏或缺失的key会被跳过，修复时数据目录不能被其他实例打开，不支持加密的数据目录
+func Repair(dirPath string, outputDir string) (*RepairReport, error) {
+	if entries, err := os.ReadDir(outputDir); err == nil && len(entries) > 0 {
+		return nil, ErrRepairOutputNotEmpty
+	}
+
+	// 修复期间不允许其他实例打开数据目录
+	fileLock := flock.New(filepath.Join(dirPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	src := &DB{
+		options:    Options{DirPath: dirPath, IndexType: BTree},
+		mu:         new(sync.RWMutex),
+		olderFiles: make(map[uint32]*data.DataFile),
+		index:      index.NewIndexer(index.Btree, dirPath, false),
+		operands:   make(map[operandKey][]*data.LogRecordPos),
+
+		olderBlobFiles: make(map[uint32]*data.DataFile),
+		blobRefs:       make(map[operandKey]*data.LogRecordPos),
+	}
+	defer func() {
+		if src.activeFile != nil {
+			_ = src.closeDataFiles()
+		}
+	}()
+
+	// blob文件中的value写入新目录的数据文件
+	if err := src.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{OutputDir: outputDir}
+	if err := src.scanBlobFiles(report); err != nil {
+		return nil, err
+	}
+	if err := src.salvageDataFiles(report); err != nil {
+		return nil, err
+	}
+	if err := src.writeRepairedDir(outputDir, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// 扫描所有数据文件，将读取到的有效数据加载到内存索引中
+func (db *DB) salvageDataFiles(report *RepairReport) error {
+	fileIds, err := listDataFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	// 已过期的数据不再写入新的目录
+	now := time.Now().UnixNano()
+	transactionRecords := make(map[uint64][]*data.TransactionRecord)
+	var encrypted bool
+	for _, fid := range fileIds {
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		report.ScannedFiles++
+
+		lostRanges, err := scanLogRecords(context.Background(), dataFile, func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) {
+			if logRecord.Flags&data.LogRecordEncrypted != 0 {
+				encrypted = true
+				return
+			}
+			report.SalvagedRecords++
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			if seqNo == nonTransactionSeqNo {
+				db.updateIndex(realKey, logRecord, logRecordPos, now)
+			} else if logRecord.Type == data.LogRecordTxnFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					db.updateIndex(txnRecord.Record.Key, txnRecord.Record, txnRecord.Pos, now)
+				}
+				delete(transactionRecords, seqNo)
+			} else {
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
+			}
+			if seqNo > db.seqNo {
+				db.seqNo = seqNo
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			return ErrEncryptionKeyRequired
+		}
+		report.LostRanges = append(report.LostRanges, lostRanges...)
+	}
+
+	for _, records := range transactionRecords {
+		report.DroppedTxnRecords += len(records)
+	}
+	return nil
+}
+
+// 扫描所有blob文件，记录其中无法读取的字节范围
+func (db *DB) scanBlobFiles(report *RepairReport) error {
+	blobFiles := sortedDataFiles(db.olderBlobFiles)
+	if db.activeBlobFile != nil {
+		blobFiles = append(blobFiles, db.activeBlobFile)
+	}
+	for _, blobFile := range blobFiles {
+		lostRanges, err := scanLogRecords(context.Background(), blobFile, func(*data.LogRecord, *data.LogRecordPos) {})
+		if err != nil {
+			return err
+		}
+		report.ScannedBlobFiles++
+		for _, lost := range lostRanges {
+			lost.Blob = true
+			report.LostRanges = append(report.LostRanges, lost)
+		}
+	}
+	return nil
+}
+
+// 逐条读取数据文件中的记录，遇到无法读取的数据时按窗口读取后续数据，查找下一条校验通过的记录
+// 返回无法读取的字节范围
+func scanLogRecords(ctx context.Context, dataFile *data.DataFile,
+	fn func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos)) ([]LostRange, error) {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	var lostRanges []LostRange
+	var offset int64
+	for offset < size {
+		if err := ctx.Err(); err != nil {
+			return lostRanges, err
+		}
+
+		logRecord, recordSize, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			next, err := dataFile.FindLogRecord(offset + 1)
+			if err == io.EOF {
+				lostRanges = append(lostRanges, LostRange{Fid: dataFile.FileId, Start: offset, End: size})
+				break
+			}
+			if err != nil {
+				return lostRanges, err
+			}
+			lostRanges = append(lostRanges, LostRange{Fid: dataFile.FileId, Start: offset, End: next})
+			offset = next
+			continue
+		}
+
+		fn(logRecord, &data.LogRecordPos{
+			Fid:       dataFile.FileId,
+			Offset:    offset,
+			Size:      uint32(recordSize),
+			ExpiresAt: logRecord.ExpiresAt,
+		})
+		offset += recordSize
+	}
+	return lostRanges, nil
+}
+
+// 将内存索引中的有效数据写入新的目录
+// 布局与merge之后相同，单条记录的key写入前面的数据文件并生成hint索引，
+// 操作数链写入最后的活跃文件，启动时回放重建
+func (db *DB) writeRepairedDir(outputDir string, report *RepairReport) (err error) {
+	outOptions := DefaultOptions
+	outOptions.DirPath = outputDir
+	outOptions.MMapAtStartup = false
+	out, err := Open(outOptions)
+	if err != nil {
+		return err
+	}
+	// 关闭时写入 seq-no 文件，失败时修复结果不完整
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if out.activeFile == nil {
+		if err := out.setActiveDataFile(); err != nil {
+			return err
+		}
+	}
+
+	hintFile, err := data.OpenHintFile(outputDir)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	// b+树索引不回放数据文件，需要重新生成索引文件
+	var bptree index.Indexer
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, index.BPTreeIndexFileName)); err == nil {
+		bptree = index.NewIndexer(index.BPTree, outputDir, false)
+		defer bptree.Close()
+	}
+
+	var chains [][]*data.LogRecordPos
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		pos := iter.Value()
+		if chain, ok := db.operands[newOperandKey(pos)]; ok {
+			chains = append(chains, chain)
+			continue
+		}
+
+		logRecord, err := db.readLogRecordAt(pos)
+		if err != nil {
+			if db.skipLostBlob(pos, report) {
+				continue
+			}
+			return err
+		}
+		newPos, err := copyRecordTo(out, logRecord)
+		if err != nil {
+			return err
+		}
+		if err := hintFile.WriteHintRecord(iter.Key(), newPos); err != nil {
+			return err
+		}
+		if bptree != nil {
+			bptree.Put(iter.Key(), newPos)
+		}
+		report.LiveKeys++
+	}
+
+	// 操作数链写入新的活跃文件，切换时同时生成数据文件的hint文件
+	if err := out.sealActiveFile(); err != nil {
+		return err
+	}
+	nonMergeFileId := out.activeFile.FileId
+	for _, chain := range chains {
+		// 先读取整条链，其中的blob记录无法读取时丢弃这个key
+		records := make([]*data.LogRecord, 0, len(chain))
+		for _, pos := range chain {
+			logRecord, err := db.readLogRecordAt(pos)
+			if err != nil {
+				if db.skipLostBlob(pos, report) {
+					records = nil
+					break
+				}
+				return err
+			}
+			records = append(records, logRecord)
+		}
+		if records == nil {
+			continue
+		}
+		for _, logRecord := range records {
+			if _, err := copyRecordTo(out, logRecord); err != nil {
+				return err
+			}
+		}
+		report.LiveKeys++
+	}
+
+	if err := hintFile.Sync(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := writeMergeFinishedFile(outputDir, nonMergeFileId); err != nil {
+		return err
+	}
+
+	out.seqNo = db.seqNo
+	return nil
+}
+
+// 指针记录引用的blob记录无法读取时跳过这个key，返回 false 表示不是blob记录的读取错误
+// 扫描blob文件时没有发现的丢失范围（例如blob文件缺失）补充到报告中
+func (db *DB) skipLostBlob(pos *data.LogRecordPos, report *RepairReport) bool {
+	blobPos, ok := db.blobRefs[newOperandKey(pos)]
+	if !ok {
+		return false
+	}
+	report.SkippedKeys++
+	lost := LostRange{Fid: blobPos.Fid, Start: blobPos.Offset, End: blobPos.Offset + int64(blobPos.Size), Blob: true}
+	for _, r := range report.LostRanges {
+		if r.Blob && r.Fid == lost.Fid && r.Start <= lost.Start && r.End >= lost.End {
+			return true
+		}
+	}
+	report.LostRanges = append(report.LostRanges, lost)
+	return true
+}
+
+// 将记录以非事务的方式写入另一个实例，新的目录中只有这一条记录，清除搬移标记
+func copyRecordTo(out *DB, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+	logRecord.Flags &^= data.LogRecordRelocated
+	return out.appendLogRecord(logRecord)
+}
@@ -0,0 +1,167 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepair(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = counterMergeOperator{}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 900; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.MergeValue([]byte("counter"), []byte("2")))
+	assert.Nil(t, db.MergeValue([]byte("counter"), []byte("3")))
+
+	// 没有完成标记的事务
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("uncommitted"), 100),
+		Value: []byte("value"),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 损坏第一个数据文件中间的数据
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 16*1024)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	out := dir + "-repair"
+	defer os.RemoveAll(out)
+	report, err := Repair(dir, out)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.DroppedTxnRecords)
+	assert.Equal(t, 1, len(report.LostRanges))
+	lost := report.LostRanges[0]
+	assert.Equal(t, uint32(0), lost.Fid)
+	assert.True(t, lost.Start <= 16*1024 && lost.End > 16*1024)
+
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName, data.SeqNoFileName} {
+		_, err := os.Stat(filepath.Join(out, name))
+		assert.Nil(t, err)
+	}
+
+	// 除最后的活跃文件之外，每个数据文件都有hint文件
+	dataFiles, err := filepath.Glob(filepath.Join(out, "*"+data.DataFileNameSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(dataFiles) > 1)
+	for fid := range dataFiles[:len(dataFiles)-1] {
+		_, err := os.Stat(data.GetDataFileHintName(out, uint32(fid)))
+		assert.Nil(t, err)
+	}
+
+	// 输出目录不为空时拒绝写入
+	_, err = Repair(dir, out)
+	assert.Equal(t, ErrRepairOutputNotEmpty, err)
+
+	repaired, err := Open(Options{
+		DirPath:       out,
+		DataFileSize:  opts.DataFileSize,
+		IndexType:     BTree,
+		MergeOperator: opts.MergeOperator,
+	})
+	assert.Nil(t, err)
+	defer repaired.Close()
+
+	assert.Equal(t, report.LiveKeys, len(repaired.ListKeys()))
+	_, err = repaired.Get(utils.GetTestKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = repaired.Get(utils.GetTestKey(899))
+	assert.Nil(t, err)
+	_, err = repaired.Get([]byte("uncommitted"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := repaired.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), value)
+	value, err = repaired.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("5"), value)
+}
+
+func TestRepair_LostBlob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(10000+i), utils.RandomValue(8192)))
+	}
+	assert.True(t, len(db.olderBlobFiles) > 1)
+	lastBlobFid := db.activeBlobFile.FileId
+	missingPos := db.blobRefs[newOperandKey(db.index.Get(utils.GetTestKey(10009)))]
+	assert.Equal(t, lastBlobFid, missingPos.Fid)
+	assert.Nil(t, db.Close())
+
+	// 损坏第一个blob文件中的第一条记录，删除最后一个blob文件
+	f, err := os.OpenFile(data.GetBlobFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 1024)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Nil(t, os.Remove(data.GetBlobFileName(dir, lastBlobFid)))
+
+	out := dir + "-repair"
+	defer os.RemoveAll(out)
+	report, err := Repair(dir, out)
+	assert.Nil(t, err)
+
+	var skipped []string
+	repaired, err := Open(Options{DirPath: out, DataFileSize: opts.DataFileSize, IndexType: BTree})
+	assert.Nil(t, err)
+	defer repaired.Close()
+	for i := 10000; i < 10010; i++ {
+		if _, err := repaired.Get(utils.GetTestKey(i)); err == ErrKeyNotFound {
+			skipped = append(skipped, string(utils.GetTestKey(i)))
+		}
+	}
+	assert.Equal(t, report.SkippedKeys, len(skipped))
+	assert.Contains(t, skipped, string(utils.GetTestKey(10000)))
+	assert.Contains(t, skipped, string(utils.GetTestKey(10009)))
+	assert.Equal(t, 110-report.SkippedKeys, report.LiveKeys)
+	assert.Equal(t, report.LiveKeys, len(repaired.ListKeys()))
+	_, err = repaired.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+
+	// 损坏的范围在扫描blob文件时发现，缺失的blob文件按引用的记录补充
+	var corrupted, missing bool
+	for _, lost := range report.LostRanges {
+		assert.True(t, lost.Blob)
+		if lost.Fid == 0 && lost.Start <= 1024 && lost.End > 1024 {
+			corrupted = true
+		}
+		if lost.Fid == lastBlobFid && lost.Start == missingPos.Offset {
+			missing = true
+		}
+	}
+	assert.True(t, corrupted)
+	assert.True(t, missing)
+}
@@ -0,0 +1,97 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"runtime"
+	"sync"
+)
+
+// 启动时读取一个数据文件的任务
+type replayJob struct {
+	dataFile    *data.DataFile
+	offset      int64 // 开始读取的位置，读取之后为最后一条完整记录之后的位置
+	isActive    bool
+	entries     []*hintEntry
+	discardSize int64
+	err         error
+	done        chan struct{}
+}
+
+// 读取数据文件的工作池，多个数据文件并发读取和解码，调用方按照文件id的顺序等待结果并更新索引
+// 已经读取但是还没有更新索引的文件数量有上限，避免占用过多内存
+type replayPool struct {
+	jobCh  chan *replayJob
+	tokens chan struct{}
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (db *DB) startReplayPool(jobs []*replayJob) *replayPool {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	p := &replayPool{
+		jobCh:  make(chan *replayJob),
+		tokens: make(chan struct{}, 2*workers),
+		stopCh: make(chan struct{}),
+	}
+
+	// 按照文件id的顺序分发任务
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(p.jobCh)
+		for _, job := range jobs {
+			select {
+			case p.tokens <- struct{}{}:
+			case <-p.stopCh:
+				return
+			}
+			select {
+			case p.jobCh <- job:
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobCh {
+				db.runReplayJob(job)
+				close(job.done)
+			}
+		}()
+	}
+	return p
+}
+
+// 读取数据文件中的记录，不修改内存索引
+func (db *DB) runReplayJob(job *replayJob) {
+	if !job.isActive && job.offset == 0 {
+		// 旧的数据文件从hint文件读取
+		job.entries, job.discardSize, job.offset, job.err = db.readOlderFileEntries(job.dataFile)
+		return
+	}
+	job.entries, job.offset, job.err = db.scanDataFile(job.dataFile, job.offset)
+}
+
+// 等待任务完成，更新索引之后调用 release
+func (p *replayPool) wait(job *replayJob) {
+	<-job.done
+}
+
+// 任务的结果已经更新到索引，允许继续读取之后的文件
+func (p *replayPool) release(job *replayJob) {
+	job.entries = nil
+	<-p.tokens
+}
+
+// 停止分发任务并等待正在执行的任务完成
+func (p *replayPool) stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
@@ -0,0 +1,71 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ParallelReplay(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-parallel-replay")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 覆盖写入的key分布在多个数据文件中
+	expected := make(map[string][]byte)
+	for i := 0; i < 3000; i++ {
+		key, value := utils.GetTestKey(i%500), utils.RandomValue(32)
+		assert.Nil(t, db.Put(key, value))
+		expected[string(key)] = value
+	}
+
+	// 跨越多个数据文件的事务，完成标记在最后一个文件中
+	wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchNum: 10000})
+	for i := 0; i < 1000; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(32)
+		assert.Nil(t, wb.Put(key, value))
+		expected[string(key)] = value
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 900; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+
+	check := func(db *DB) {
+		assert.Equal(t, len(expected), len(db.ListKeys()))
+		for key, value := range expected {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check(db)
+	reclaimSize := db.Stat().ReclaimableSize
+	assert.Nil(t, db.Close())
+
+	// 没有hint文件时并发回放全部数据文件
+	fileIds, err := listDataFileIds(dir)
+	assert.Nil(t, err)
+	assert.True(t, len(fileIds) > 10)
+	for _, fileId := range fileIds {
+		_ = os.Remove(data.GetDataFileHintName(dir, uint32(fileId)))
+	}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Equal(t, reclaimSize, db.Stat().ReclaimableSize)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Equal(t, reclaimSize, db.Stat().ReclaimableSize)
+}
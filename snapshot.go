@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"time"
+)
+
+// 数据库的只读快照，读取到的数据固定为创建时刻的状态
+type Snapshot struct {
+	db       *DB
+	index    index.Indexer // 创建时刻的索引副本
+	seqNo    uint64        // 创建时刻的事务序列号
+	readTime int64         // 创建时刻，用于判断数据是否过期
+	released bool          // 是否已经释放，持有db.mu读写
+}
+
+// 创建快照，使用完毕后需要调用 Release 释放
+// 只有BTree索引支持快照，基于写时复制创建，代价与索引大小无关，其他索引返回 ErrSnapshotUnsupported
+// 未释放的快照不会阻止merge和blob压缩，merge之后的数据文件在重启时才替换，压缩之后的旧blob文件在快照全部释放之后才删除
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	if db.options.IndexType != BTree {
+		return nil, ErrSnapshotUnsupported
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snap := &Snapshot{
+		db:       db,
+		index:    db.index.Snapshot(),
+		seqNo:    db.seqNo,
+		readTime: time.Now().UnixNano(),
+	}
+	db.snapshots[snap] = struct{}{}
+	return snap, nil
+}
+
+// 快照创建时的事务序列号
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// 读取快照中的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(s.readTime) {
+		return nil, ErrKeyNotFound
+	}
+	return s.db.getValueByPosition(logRecordPos)
+}
+
+// 创建快照上的迭代器，快照已经释放时返回无效的迭代器
+func (s *Snapshot) NewIterator(options IteratorOptions) *Iterator {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return newIterator(s.db, index.NewBTree(), options, s.readTime)
+	}
+	return newIterator(s.db, s.index, options, s.readTime)
+}
+
+// 遍历快照中的所有数据，fn返回false则停止遍历
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return ErrSnapshotReleased
+	}
+	return s.db.foldIndexLocked(s.index, s.readTime, fn)
+}
+
+// 释放快照，释放后不能再读取
+func (s *Snapshot) Release() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	delete(s.db.snapshots, s)
+	_ = s.index.Close()
+
+	// 所有快照释放后，清理被覆盖的操作数链
+	if len(s.db.snapshots) == 0 {
+		for _, chain := range s.db.staleOperands {
+			s.db.removeOperands(chain)
+		}
+		s.db.staleOperands = nil
+	}
+}
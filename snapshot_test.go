@@ -0,0 +1,150 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(24)
+	val2 := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), val2)
+	assert.Nil(t, err)
+
+	snap, err := db.NewSnapshot()
+	assert.Nil(t, err)
+
+	// 创建快照之后继续写入
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 快照中仍然是旧的数据
+	v1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v1)
+	v2, err := snap.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v2)
+	_, err = snap.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iterator := snap.NewIterator(DefaultIteratorOptions)
+	var values [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		assert.Nil(t, err)
+		values = append(values, value)
+	}
+	iterator.Close()
+	assert.Equal(t, [][]byte{val1, val2}, values)
+
+	var foldNum int
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		foldNum++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, foldNum)
+
+	// 快照未释放时也可以merge，merge之后快照仍然可以读取
+	err = db.Merge()
+	assert.Nil(t, err)
+	v1, err = snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v1)
+
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	err = snap.Fold(func(key []byte, value []byte) bool { return true })
+	assert.Equal(t, ErrSnapshotReleased, err)
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	assert.False(t, iter.Valid())
+	iter.Close()
+	assert.Equal(t, 0, len(db.snapshots))
+
+	// 释放与读取并发执行
+	snap, err = db.NewSnapshot()
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, _ = snap.Get(utils.GetTestKey(1))
+			_ = snap.Fold(func(key []byte, value []byte) bool { return true })
+			snap.NewIterator(DefaultIteratorOptions).Close()
+		}
+	}()
+	snap.Release()
+	<-done
+}
+
+func TestDB_NewSnapshotUnsupported(t *testing.T) {
+	for _, indexType := range []IndexerType{ART, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-unsupported")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		_, err = db.NewSnapshot()
+		assert.Equal(t, ErrSnapshotUnsupported, err)
+		if indexType == BPlusTree {
+			destroyDB(db)
+			continue
+		}
+
+		// 事务直接读取最新的数据，提交时检查冲突
+		assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+		txn := db.Begin()
+		_, err = txn.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Put(utils.GetTestKey(2), utils.RandomValue(10)))
+		assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+		assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+		// 再次读取到新的值不会掩盖第一次读取之后的修改
+		txn = db.Begin()
+		_, err = txn.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+		_, err = txn.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Put(utils.GetTestKey(2), utils.RandomValue(10)))
+		assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+		txn = db.Begin()
+		iter := txn.NewIterator(DefaultIteratorOptions)
+		assert.True(t, iter.Valid())
+		_, err = iter.Value()
+		assert.Nil(t, err)
+		iter.Close()
+		assert.Nil(t, txn.Put(utils.GetTestKey(2), utils.RandomValue(10)))
+		assert.Nil(t, txn.Commit())
+		assert.Equal(t, 0, len(db.snapshots))
+
+		destroyDB(db)
+	}
+}
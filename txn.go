@@ -0,0 +1,329 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 乐观读写事务，读取基于第一次读取时的快照，提交时检查读取过的key是否被修改
+// 索引不支持快照时读取最新的数据，同样由提交时的冲突检测保证读取过的key没有被其他写入修改
+type Txn struct {
+	options       WriteBatchOptions
+	mu            *sync.Mutex
+	db            *DB
+	snapshot      *Snapshot                     // 第一次读取时创建的快照，只写入的事务或者索引不支持快照时为nil
+	pendingWrites *writeSet                     // 暂存用户写入的数据
+	readSet       map[string]*data.LogRecordPos // 读取过的key及其在快照中的位置，nil表示不存在
+	finished      bool                          // 是否已经提交或回滚
+}
+
+// 开启一个事务
+func (db *DB) Begin() *Txn {
+	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot begin transaction without sequence number file")
+	}
+	return &Txn{
+		options:       DefaultWriteBatchOptions,
+		mu:            new(sync.Mutex),
+		db:            db,
+		pendingWrites: newWriteSet(),
+		readSet:       make(map[string]*data.LogRecordPos),
+	}
+}
+
+// 读取数据，优先返回事务中未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if record := txn.pendingWrites.get(key); record != nil {
+		if record.Type == data.LogRecordDeleted || record.IsExpired(time.Now().UnixNano()) {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	idx, readTime := txn.db.index, time.Now().UnixNano()
+	if snapshot := txn.readSnapshot(); snapshot != nil {
+		idx, readTime = snapshot.index, snapshot.readTime
+	}
+	logRecordPos := idx.Get(key)
+	txn.addRead(key, logRecordPos)
+	if logRecordPos == nil || logRecordPos.IsExpired(readTime) {
+		return nil, ErrKeyNotFound
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+	return txn.db.getValueByPosition(logRecordPos)
+}
+
+// 写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	return txn.PutWithTTL(key, value, 0)
+}
+
+// 写入带过期时间的数据，ttl <= 0 表示永不过期
+func (txn *Txn) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.pendingWrites.put(&data.LogRecord{Key: key, Value: value, ExpiresAt: expiresAtFromTTL(ttl)})
+	return nil
+}
+
+// 删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.pendingWrites.put(&data.LogRecord{Key: key, Type: data.LogRecordDeleted})
+	return nil
+}
+
+// 提交事务，读取过的key在事务开始后被修改则返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+	defer txn.releaseSnapshot()
+
+	if txn.pendingWrites.len() == 0 {
+		return nil
+	}
+
+	if uint(txn.pendingWrites.len()) > txn.options.MaxBatchNum {
+		return ErrExceedMaxBatchNum
+	}
+
+	// 加锁保证冲突检测和写入的原子性
+	syncWrites := txn.options.SyncWrites || txn.db.options.SyncWrites
+	return txn.db.writeWithSync(syncWrites, func() error {
+		for key, readPos := range txn.readSet {
+			if !isSamePos(readPos, txn.db.index.Get([]byte(key))) {
+				return ErrTxnConflict
+			}
+		}
+		return txn.db.commitPendingWrites(txn.pendingWrites.ordered())
+	})
+}
+
+// 回滚事务，丢弃所有未提交的写入
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return
+	}
+	txn.finished = true
+	txn.pendingWrites = nil
+	txn.releaseSnapshot()
+}
+
+// 第一次读取时创建快照，索引不支持快照时返回nil，调用方需要持有txn.mu
+func (txn *Txn) readSnapshot() *Snapshot {
+	if txn.snapshot == nil {
+		if snapshot, err := txn.db.NewSnapshot(); err == nil {
+			txn.snapshot = snapshot
+		}
+	}
+	return txn.snapshot
+}
+
+// 释放读取时创建的快照，调用方需要持有txn.mu
+func (txn *Txn) releaseSnapshot() {
+	if txn.snapshot != nil {
+		txn.snapshot.Release()
+	}
+}
+
+// 记录读取过的key
+func (txn *Txn) recordRead(key []byte, pos *data.LogRecordPos) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.addRead(key, pos)
+}
+
+// 记录读取过的key，只保留第一次读取到的位置，没有快照时之后读取到的新位置不能掩盖冲突，调用方需要持有txn.mu
+func (txn *Txn) addRead(key []byte, pos *data.LogRecordPos) {
+	if _, ok := txn.readSet[string(key)]; !ok {
+		txn.readSet[string(key)] = pos
+	}
+}
+
+// 判断两个索引位置是否指向同一条记录
+func isSamePos(a, b *data.LogRecordPos) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Fid == b.Fid && a.Offset == b.Offset
+}
+
+// 事务迭代器，将事务中未提交的写入叠加在快照之上
+type TxnIterator struct {
+	txn      *Txn
+	baseIter *Iterator                  // 快照上的迭代器，索引不支持快照时为数据库上的迭代器
+	pending  map[string]*data.LogRecord // 创建迭代器时的暂存写入
+	keys     [][]byte                   // 暂存写入中符合前缀的key，已排序
+	keyIdx   int                        // 当前暂存写入的下标
+	reverse  bool                       // 是否倒序
+}
+
+// 创建事务迭代器
+func (txn *Txn) NewIterator(options IteratorOptions) *TxnIterator {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	pending := make(map[string]*data.LogRecord, txn.pendingWrites.len())
+	keys := make([][]byte, 0, txn.pendingWrites.len())
+	for _, record := range txn.pendingWrites.ordered() {
+		key := string(record.Key)
+		if !bytes.HasPrefix([]byte(key), options.Prefix) {
+			continue
+		}
+		pending[key] = record
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if options.Reverse {
+			return bytes.Compare(keys[i], keys[j]) > 0
+		}
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	var baseIter *Iterator
+	if snapshot := txn.readSnapshot(); snapshot != nil {
+		baseIter = snapshot.NewIterator(options)
+	} else {
+		baseIter = txn.db.NewIterator(options)
+	}
+	it := &TxnIterator{
+		txn:      txn,
+		baseIter: baseIter,
+		pending:  pending,
+		keys:     keys,
+		reverse:  options.Reverse,
+	}
+	it.skipDeleted()
+	return it
+}
+
+// 回到起始位置
+func (it *TxnIterator) Rewind() {
+	it.baseIter.Rewind()
+	it.keyIdx = 0
+	it.skipDeleted()
+}
+
+// 从这个key开始遍历
+func (it *TxnIterator) Seek(key []byte) {
+	it.baseIter.Seek(key)
+	it.keyIdx = sort.Search(len(it.keys), func(i int) bool {
+		if it.reverse {
+			return bytes.Compare(it.keys[i], key) <= 0
+		}
+		return bytes.Compare(it.keys[i], key) >= 0
+	})
+	it.skipDeleted()
+}
+
+// 跳转到下一个key
+func (it *TxnIterator) Next() {
+	it.advance()
+	it.skipDeleted()
+}
+
+// 当前位置是否有效
+func (it *TxnIterator) Valid() bool {
+	return it.baseIter.Valid() || it.keyIdx < len(it.keys)
+}
+
+// 当前key
+func (it *TxnIterator) Key() []byte {
+	if it.compare() < 0 {
+		return it.baseIter.Key()
+	}
+	return it.keys[it.keyIdx]
+}
+
+// 当前value，读取快照中的数据会参与提交时的冲突检测
+func (it *TxnIterator) Value() ([]byte, error) {
+	if it.compare() >= 0 {
+		return it.pending[string(it.keys[it.keyIdx])].Value, nil
+	}
+	it.txn.recordRead(it.baseIter.Key(), it.baseIter.indexIter.Value())
+	return it.baseIter.Value()
+}
+
+// 关闭迭代器
+func (it *TxnIterator) Close() {
+	it.baseIter.Close()
+}
+
+// 比较两个来源的当前key，小于0表示快照在前，大于0表示暂存写入在前，等于0表示key相同，以暂存写入为准
+func (it *TxnIterator) compare() int {
+	if !it.baseIter.Valid() {
+		return 1
+	}
+	if it.keyIdx >= len(it.keys) {
+		return -1
+	}
+	c := bytes.Compare(it.baseIter.Key(), it.keys[it.keyIdx])
+	if it.reverse {
+		c = -c
+	}
+	return c
+}
+
+// 前进一个位置，key相同时两个来源同时前进
+func (it *TxnIterator) advance() {
+	c := it.compare()
+	if c <= 0 {
+		it.baseIter.Next()
+	}
+	if c >= 0 {
+		it.keyIdx++
+	}
+}
+
+// 跳过事务中已删除或已过期的key
+func (it *TxnIterator) skipDeleted() {
+	now := time.Now().UnixNano()
+	for it.Valid() && it.compare() >= 0 {
+		record := it.pending[string(it.keys[it.keyIdx])]
+		if record.Type != data.LogRecordDeleted && !record.IsExpired(now) {
+			return
+		}
+		it.advance()
+	}
+}
@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	// 读到自己的写入
+	val2 := utils.RandomValue(10)
+	err = txn.Put(utils.GetTestKey(2), val2)
+	assert.Nil(t, err)
+	v2, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v2)
+
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 未提交之前对数据库不可见
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	v2, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v2)
+
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 重启之后数据仍然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	v2, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v2)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_TxnConflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	// 读取过的key被修改，提交失败
+	txn1 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 读取时不存在的key被其他写入创建，提交失败
+	txn2 := db.Begin()
+	_, err = txn2.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn2.Put(utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 只修改了未读取的key，提交成功
+	txn3 := db.Begin()
+	err = txn3.Put(utils.GetTestKey(4), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Nil(t, err)
+
+	// 第一次读取时才创建快照，回滚之后快照被释放
+	txn4 := db.Begin()
+	assert.Nil(t, txn4.Put(utils.GetTestKey(5), utils.RandomValue(10)))
+	assert.Equal(t, 0, len(db.snapshots))
+	_, err = txn4.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.snapshots))
+	txn4.Rollback()
+	assert.Equal(t, 0, len(db.snapshots))
+}
+
+func TestDB_TxnIterator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "c", "e"} {
+		err = db.Put([]byte(key), []byte("db-"+key))
+		assert.Nil(t, err)
+	}
+
+	txn := db.Begin()
+	defer txn.Rollback()
+	assert.Nil(t, txn.Put([]byte("b"), []byte("txn-b")))
+	assert.Nil(t, txn.Put([]byte("c"), []byte("txn-c")))
+	assert.Nil(t, txn.Delete([]byte("e")))
+	assert.Nil(t, txn.Put([]byte("f"), []byte("txn-f")))
+
+	collect := func(it *TxnIterator) []string {
+		var res []string
+		for ; it.Valid(); it.Next() {
+			val, err := it.Value()
+			assert.Nil(t, err)
+			res = append(res, string(it.Key())+"="+string(val))
+		}
+		return res
+	}
+
+	iter1 := txn.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, []string{"a=db-a", "b=txn-b", "c=txn-c", "f=txn-f"}, collect(iter1))
+	iter1.Close()
+
+	iter2 := txn.NewIterator(IteratorOptions{Reverse: true})
+	assert.Equal(t, []string{"f=txn-f", "c=txn-c", "b=txn-b", "a=db-a"}, collect(iter2))
+	iter2.Seek([]byte("b"))
+	assert.Equal(t, []string{"b=txn-b", "a=db-a"}, collect(iter2))
+	iter2.Close()
+
+	iter3 := txn.NewIterator(DefaultIteratorOptions)
+	iter3.Seek([]byte("d"))
+	assert.Equal(t, []string{"f=txn-f"}, collect(iter3))
+	iter3.Close()
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -88,3 +89,35 @@ func CopyDir(src, dest string, exclude []string) error {
 		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
 	})
 }
+
+// LinkOrCopyFile 为文件创建硬链接，无法创建时（例如跨文件系统）拷贝文件
+func LinkOrCopyFile(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	return CopyFile(src, dest)
+}
+
+// CopyFile 拷贝文件并持久化
+func CopyFile(src, dest string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		return err
+	}
+	return destFile.Sync()
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -21,3 +22,23 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.True(t, size > 0)
 	t.Log("AvailableDiskSize:", size)
 }
+
+func TestLinkOrCopyFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-link")
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.Nil(t, os.WriteFile(src, []byte("bitcask"), 0644))
+
+	linked := filepath.Join(dir, "linked")
+	assert.Nil(t, LinkOrCopyFile(src, linked))
+	data, err := os.ReadFile(linked)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), data)
+
+	copied := filepath.Join(dir, "copied")
+	assert.Nil(t, CopyFile(src, copied))
+	data, err = os.ReadFile(copied)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), data)
+}
@@ -0,0 +1,148 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"context"
+	"sort"
+)
+
+// 指向无效记录的索引项
+type DanglingEntry struct {
+	Key []byte
+	Pos *data.LogRecordPos
+	Err error // 读取记录失败的原因，或者 ErrIndexPointsToDeleted、ErrIndexKeyMismatch
+}
+
+// 在线校验的结果
+type VerifyReport struct {
+	CheckedFiles      int             // 校验的旧数据文件数量
+	CheckedBlobFiles  int             // 校验的旧blob文件数量
+	CheckedRecords    int             // 校验通过的记录数量，包括blob记录
+	CheckedKeys       int             // 校验的索引项数量
+	CorruptRanges     []LostRange     // 旧数据文件中校验失败的字节范围
+	CorruptBlobRanges []LostRange     // 旧blob文件中校验失败的字节范围
+	DanglingEntries   []DanglingEntry // 指向无效记录的索引项
+}
+
+// 是否没有发现任何问题
+func (r *VerifyReport) OK() bool {
+	return len(r.CorruptRanges) == 0 && len(r.CorruptBlobRanges) == 0 && len(r.DanglingEntries) == 0
+}
+
+// 在线校验数据完整性，不影响正常读写
+// 逐条校验所有旧数据文件和旧blob文件中记录的CRC，并确认索引中的每一项都指向一条可以读取、没有被删除并且key一致的记录，
+// 索引项指向操作数时校验整条操作数链，指向blob文件时同时校验blob记录
+// 活跃文件仍在写入，只校验其中被索引引用的记录
+// ctx 结束时返回已经校验的部分结果和 ctx 的错误
+func (db *DB) Verify(ctx context.Context) (*VerifyReport, error) {
+	// 旧数据文件在重启之前不会再被修改，可以不加锁读取，校验完成之前 CompactBlobs 不会删除blob文件
+	db.mu.Lock()
+	db.blobFilePins++
+	olderFiles := sortedDataFiles(db.olderFiles)
+	olderBlobFiles := sortedDataFiles(db.olderBlobFiles)
+	db.mu.Unlock()
+	defer db.unpinBlobFiles()
+
+	report := &VerifyReport{}
+	countRecord := func(*data.LogRecord, *data.LogRecordPos) {
+		report.CheckedRecords++
+	}
+	for _, dataFile := range olderFiles {
+		corruptRanges, err := scanLogRecords(ctx, dataFile, countRecord)
+		report.CorruptRanges = append(report.CorruptRanges, corruptRanges...)
+		if err != nil {
+			return report, err
+		}
+		report.CheckedFiles++
+	}
+	for _, blobFile := range olderBlobFiles {
+		corruptRanges, err := scanLogRecords(ctx, blobFile, countRecord)
+		for _, corrupt := range corruptRanges {
+			corrupt.Blob = true
+			report.CorruptBlobRanges = append(report.CorruptBlobRanges, corrupt)
+		}
+		if err != nil {
+			return report, err
+		}
+		report.CheckedBlobFiles++
+	}
+
+	// 索引迭代器分批读取索引，不会长时间持有锁，数据文件在重启之前不会被删除，旧的位置仍然可以读取
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.CheckedKeys++
+
+		key, pos := iter.Key(), iter.Value()
+		if err := db.verifyIndexEntry(key, pos); err != nil {
+			report.DanglingEntries = append(report.DanglingEntries, DanglingEntry{
+				Key: append([]byte(nil), key...),
+				Pos: pos,
+				Err: err,
+			})
+		}
+	}
+	return report, nil
+}
+
+// 校验索引项指向的记录，不使用缓存
+func (db *DB) verifyIndexEntry(key []byte, pos *data.LogRecordPos) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	logRecord, err := db.verifyRecord(key, pos)
+	if err != nil || logRecord.Type != data.LogRecordMerge {
+		return err
+	}
+
+	// 操作数链中的每一条记录都需要可以读取
+	chain, ok := db.operands[newOperandKey(pos)]
+	if !ok {
+		// 校验期间key被覆盖，操作数链已经释放
+		if !isSamePos(db.index.Get(key), pos) {
+			return nil
+		}
+		return ErrDataDirectoryCorrupted
+	}
+	for _, chainPos := range chain {
+		if chainPos.Fid == pos.Fid && chainPos.Offset == pos.Offset {
+			continue
+		}
+		if _, err := db.verifyRecord(key, chainPos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取并校验一条记录，调用方需要持有db.mu
+func (db *DB) verifyRecord(key []byte, pos *data.LogRecordPos) (*data.LogRecord, error) {
+	logRecord, err := db.readLogRecordFromFile(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if logRecord.Type == data.LogRecordDeleted || logRecord.Type == data.LogRecordTxnFinished {
+		return nil, ErrIndexPointsToDeleted
+	}
+	if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, key) {
+		return nil, ErrIndexKeyMismatch
+	}
+	return logRecord, nil
+}
+
+// 按照文件id从小到大的顺序返回文件
+func sortedDataFiles(files map[uint32]*data.DataFile) []*data.DataFile {
+	dataFiles := make([]*data.DataFile, 0, len(files))
+	for _, dataFile := range files {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].FileId < dataFiles[j].FileId
+	})
+	return dataFiles
+}
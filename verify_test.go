@@ -0,0 +1,132 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Verify(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MMapAtStartup = false
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+
+	report, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, len(db.olderFiles), report.CheckedFiles)
+	assert.Equal(t, 1000, report.CheckedKeys)
+
+	// 在数据库打开期间损坏旧数据文件
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 16*1024)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	// 索引指向被删除的记录
+	tombstone := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff}
+	assert.Nil(t, db.Delete(utils.GetTestKey(999)))
+	db.index.Put(utils.GetTestKey(999), tombstone)
+
+	// 索引指向其他key的记录
+	db.index.Put(utils.GetTestKey(998), db.index.Get(utils.GetTestKey(990)))
+
+	report, err = db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 1, len(report.CorruptRanges))
+	assert.Equal(t, uint32(0), report.CorruptRanges[0].Fid)
+
+	// 损坏的记录所在的key不能读取
+	var corruptedKeys int
+	dangling := make(map[string]error)
+	for _, entry := range report.DanglingEntries {
+		dangling[string(entry.Key)] = entry.Err
+		if entry.Err == data.ErrInvalidCRC {
+			corruptedKeys++
+		}
+	}
+	assert.True(t, corruptedKeys > 0)
+	assert.Equal(t, ErrIndexPointsToDeleted, dangling[string(utils.GetTestKey(999))])
+	assert.Equal(t, ErrIndexKeyMismatch, dangling[string(utils.GetTestKey(998))])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.Verify(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDB_VerifyBlobAndOperands(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MMapAtStartup = false
+	opts.ValueThreshold = 256
+	opts.MergeOperator = counterMergeOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 操作数链跨越多个数据文件
+	assert.Nil(t, db.MergeValue([]byte("counter"), []byte("1")))
+	for i := 0; i < 1500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		if i%100 == 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(10000+i), utils.RandomValue(8192)))
+			assert.Nil(t, db.MergeValue([]byte("counter"), []byte("1")))
+		}
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	assert.True(t, len(db.olderBlobFiles) > 0)
+
+	report, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, len(db.olderBlobFiles), report.CheckedBlobFiles)
+
+	// 损坏操作数链中的第一条记录和旧blob文件
+	chain := db.operands[newOperandKey(db.index.Get([]byte("counter")))]
+	assert.True(t, len(chain) > 1)
+	assert.Equal(t, uint32(0), chain[0].Fid)
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), chain[0].Offset+int64(chain[0].Size)-9)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	f, err = os.OpenFile(data.GetBlobFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 1024)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	report, err = db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 1, len(report.CorruptBlobRanges))
+	assert.Equal(t, uint32(0), report.CorruptBlobRanges[0].Fid)
+
+	dangling := make(map[string]error)
+	for _, entry := range report.DanglingEntries {
+		dangling[string(entry.Key)] = entry.Err
+	}
+	assert.Equal(t, data.ErrInvalidCRC, dangling["counter"])
+	assert.Equal(t, data.ErrInvalidCRC, dangling[string(utils.GetTestKey(10000))])
+}
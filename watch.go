@@ -0,0 +1,147 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"context"
+)
+
+type WatchEventType = byte
+
+const (
+	WatchEventPut    WatchEventType = iota // 写入
+	WatchEventDelete                       // 删除
+	WatchEventMerge                        // 追加合并操作数，Value 为操作数
+	WatchEventGap                          // 缓冲区已满，这个位置之前丢弃了一个或多个批次的事件，Key 和 Value 为空
+)
+
+// 数据变更事件
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   []byte
+	Value []byte
+	SeqNo uint64 // 所属 WriteBatch 或事务的序列号，Put、Delete、MergeValue 等非事务写入为0
+}
+
+// 监听者
+type watcher struct {
+	prefix []byte
+	ch     chan *WatchEvent // 比缓冲区大小多一个位置，保证丢弃事件时可以放入 WatchEventGap
+	gap    bool             // 最后放入的是 WatchEventGap，之后连续丢弃的批次不再重复放入
+	closed bool
+}
+
+// 监听指定前缀的key的变更，prefix 为空时监听所有key
+// 每次 Put、Delete、MergeValue、WriteBatch.Commit 成功之后按提交顺序发送事件，同一批次的事件连续发送
+// 同一批次的事件带有相同的 SeqNo，非事务写入的 SeqNo 都为0，不能用来区分写入，顺序以 channel 中的顺序为准
+// 事件缓冲区大小由 Options.WatchBufferSize 指定，缓冲区放不下一个批次的全部事件时整批丢弃，
+// 不会阻塞写入，并在丢弃的位置放入一个 WatchEventGap 事件，丢弃的事件总数可以通过 Stat 查看
+// ctx 结束或数据库关闭后 channel 被关闭
+func (db *DB) Watch(ctx context.Context, prefix []byte) <-chan *WatchEvent {
+	bufferSize := db.options.WatchBufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultOptions.WatchBufferSize
+	}
+	// 拷贝一份前缀，避免调用方之后修改
+	w := &watcher{
+		prefix: append([]byte(nil), prefix...),
+		ch:     make(chan *WatchEvent, bufferSize+1),
+	}
+
+	db.mu.Lock()
+	db.watchers[w] = struct{}{}
+	db.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-db.closeCh:
+		}
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		db.closeWatcher(w)
+	}()
+
+	return w.ch
+}
+
+// 向监听者发送同一批次的事件，调用方需要持有db.mu
+func (db *DB) notifyWatchers(events []*WatchEvent) {
+	// 组提交时等到持久化之后再发送
+	if db.deferWatchEvents {
+		db.pendingWatchEvents = append(db.pendingWatchEvents, events)
+		return
+	}
+	for w := range db.watchers {
+		matched := events
+		if len(w.prefix) > 0 {
+			matched = make([]*WatchEvent, 0, len(events))
+			for _, event := range events {
+				if bytes.HasPrefix(event.Key, w.prefix) {
+					matched = append(matched, event)
+				}
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		// 只有持有db.mu时才会发送，剩余容量足够时发送不会阻塞
+		// 普通事件最多占用 cap-1 个位置，丢弃时总能放入 WatchEventGap
+		if cap(w.ch)-1-len(w.ch) < len(matched) {
+			db.droppedWatchEvents += uint64(len(matched))
+			if !w.gap {
+				w.ch <- &WatchEvent{Type: WatchEventGap}
+				w.gap = true
+			}
+			continue
+		}
+		for _, event := range matched {
+			w.ch <- event
+		}
+		w.gap = false
+	}
+}
+
+// 构造单条写入的事件，没有监听者时返回nil，调用方需要持有db.mu
+func (db *DB) newWatchEvent(typ WatchEventType, key []byte, value []byte, seqNo uint64) *WatchEvent {
+	if len(db.watchers) == 0 {
+		return nil
+	}
+	// 拷贝一份，避免调用方修改
+	return &WatchEvent{
+		Type:  typ,
+		Key:   append([]byte(nil), key...),
+		Value: append([]byte(nil), value...),
+		SeqNo: seqNo,
+	}
+}
+
+// 发送单条写入的事件，调用方需要持有db.mu
+func (db *DB) notifyWatch(typ WatchEventType, key []byte, value []byte) {
+	if event := db.newWatchEvent(typ, key, value, nonTransactionSeqNo); event != nil {
+		db.notifyWatchers([]*WatchEvent{event})
+	}
+}
+
+// 根据日志记录类型获取事件类型
+func watchEventTypeOf(typ data.LogRecordType) WatchEventType {
+	switch typ {
+	case data.LogRecordDeleted:
+		return WatchEventDelete
+	case data.LogRecordMerge:
+		return WatchEventMerge
+	default:
+		return WatchEventPut
+	}
+}
+
+// 关闭监听者，调用方需要持有db.mu
+func (db *DB) closeWatcher(w *watcher) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	delete(db.watchers, w)
+	close(w.ch)
+}
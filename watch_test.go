@@ -0,0 +1,175 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	all := db.Watch(ctx, nil)
+	prefixed := db.Watch(ctx, []byte("user-"))
+
+	assert.Nil(t, db.Put([]byte("user-1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("order-1"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user-1")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user-2"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("user-3"), []byte("d")))
+	assert.Nil(t, wb.Commit())
+
+	receive := func(ch <-chan *WatchEvent) *WatchEvent {
+		select {
+		case event := <-ch:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no watch event received")
+			return nil
+		}
+	}
+
+	event := receive(all)
+	assert.Equal(t, WatchEventPut, event.Type)
+	assert.Equal(t, []byte("user-1"), event.Key)
+	assert.Equal(t, []byte("a"), event.Value)
+	assert.Equal(t, []byte("order-1"), receive(all).Key)
+	event = receive(all)
+	assert.Equal(t, WatchEventDelete, event.Type)
+	assert.Equal(t, []byte("user-1"), event.Key)
+
+	// 同一批次的事件按写入顺序连续到达，带有相同的序列号
+	batch1, batch2 := receive(all), receive(all)
+	assert.Equal(t, []byte("user-2"), batch1.Key)
+	assert.Equal(t, []byte("user-3"), batch2.Key)
+	assert.Equal(t, batch1.SeqNo, batch2.SeqNo)
+	assert.NotEqual(t, nonTransactionSeqNo, batch1.SeqNo)
+
+	// 前缀过滤
+	assert.Equal(t, []byte("user-1"), receive(prefixed).Key)
+	assert.Equal(t, WatchEventDelete, receive(prefixed).Type)
+	receive(prefixed)
+	receive(prefixed)
+	assert.Equal(t, 0, len(prefixed))
+
+	// ctx 结束后 channel 被关闭
+	cancel()
+	_, ok := <-all
+	assert.False(t, ok)
+}
+
+func TestDB_WatchDropEvents(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-2")
+	opts.DirPath = dir
+	opts.WatchBufferSize = 2
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	events := db.Watch(context.Background(), nil)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+
+	// 缓冲区已满时不阻塞写入，多余的事件被丢弃，丢弃的位置放入一个 WatchEventGap
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, uint64(3), db.Stat().DroppedWatchEvents)
+	assert.Equal(t, utils.GetTestKey(0), (<-events).Key)
+	assert.Equal(t, utils.GetTestKey(1), (<-events).Key)
+	assert.Equal(t, WatchEventGap, (<-events).Type)
+
+	// 有空间之后继续发送，再次丢弃时重新放入 WatchEventGap
+	for i := 5; i < 9; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	assert.Equal(t, uint64(5), db.Stat().DroppedWatchEvents)
+	assert.Equal(t, utils.GetTestKey(5), (<-events).Key)
+	assert.Equal(t, utils.GetTestKey(6), (<-events).Key)
+	assert.Equal(t, WatchEventGap, (<-events).Type)
+
+	// 数据库关闭后 channel 被关闭
+	assert.Nil(t, db.Close())
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestDB_WatchPrefixAndClose(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	goroutines := runtime.NumGoroutine()
+	prefix := []byte("user-")
+	events := db.Watch(context.Background(), prefix)
+
+	// 调用方之后修改前缀不影响过滤
+	prefix[0] = 'x'
+	assert.Nil(t, db.Put([]byte("user-1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("xser-1"), []byte("b")))
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []byte("user-1"), (<-events).Key)
+
+	// ctx 不会结束时，数据库关闭之后监听的协程也会退出
+	assert.Nil(t, db.Close())
+	_, ok := <-events
+	assert.False(t, ok)
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+}
+
+func TestDB_WatchBatchOrder(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-4")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("value")))
+
+	events := db.Watch(context.Background(), nil)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 50; i > 0; i-- {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("value")))
+	}
+	// 再次写入的key移到最后，删除之后不再发送
+	assert.Nil(t, wb.Put(utils.GetTestKey(30), []byte("value")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(51), []byte("value")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(51)))
+	assert.Nil(t, wb.Commit())
+
+	var expected [][]byte
+	for i := 50; i > 0; i-- {
+		if i != 30 {
+			expected = append(expected, utils.GetTestKey(i))
+		}
+	}
+	expected = append(expected, utils.GetTestKey(30), utils.GetTestKey(0))
+	assert.Equal(t, len(expected), len(events))
+	for _, key := range expected {
+		assert.Equal(t, key, (<-events).Key)
+	}
+}